@@ -0,0 +1,138 @@
+// The consent screen of the OAuth authorization endpoint. The user logs in
+// here, the page then asks /api/oauth/authorize about the request and sends
+// the user back to the client with its answer. The session it logs in with
+// only lives as long as the page and is revoked before leaving.
+"use strict";
+
+const scopeDescriptions = {
+  "chirps:read": "Read chirps",
+  "chirps:write": "Post, edit and delete chirps for you",
+  "profile:write": "Change your email and password",
+};
+
+const authRequest = new URLSearchParams(window.location.search);
+let accessToken = "";
+let refreshToken = "";
+let mfaToken = "";
+
+function show(id) {
+  for (const section of ["login", "mfa", "consent"]) {
+    document.getElementById(section).hidden = section !== id;
+  }
+}
+
+function showError(message) {
+  const error = document.getElementById("error");
+  error.textContent = message;
+  error.hidden = !message;
+}
+
+async function api(method, path, body, token) {
+  const headers = {};
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  const response = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = response.status === 204 ? {} : await response.json().catch(() => ({}));
+  return { status: response.status, data };
+}
+
+async function leave(redirectTo) {
+  if (refreshToken) {
+    await api("POST", "/api/revoke", undefined, refreshToken).catch(() => {});
+  }
+  window.location.assign(redirectTo);
+}
+
+async function loggedIn(data) {
+  if (data.mfa_required) {
+    mfaToken = data.mfa_token;
+    show("mfa");
+    return;
+  }
+  accessToken = data.token;
+  refreshToken = data.refresh_token;
+  await loadRequest();
+}
+
+async function loadRequest() {
+  const { status, data } = await api("GET", "/api/oauth/authorize?" + authRequest, undefined, accessToken);
+  if (status === 200) {
+    document.getElementById("client-name").textContent = data.client_name;
+    document.getElementById("redirect-uri").textContent = data.redirect_uri;
+    const scopes = document.getElementById("scopes");
+    scopes.replaceChildren();
+    for (const scope of data.scopes) {
+      const item = document.createElement("li");
+      item.textContent = scopeDescriptions[scope] || scope;
+      scopes.append(item);
+    }
+    showError("");
+    show("consent");
+    return;
+  }
+  // Problems the client can be told about go back to it, the others can
+  // only be shown here.
+  if (data.redirect_to) {
+    await leave(data.redirect_to);
+    return;
+  }
+  show("");
+  showError(data.error || "This authorization request is not valid.");
+}
+
+async function decide(approve) {
+  const body = Object.fromEntries(authRequest);
+  body.approve = approve;
+  const { status, data } = await api("POST", "/api/oauth/authorize", body, accessToken);
+  if (data.redirect_to) {
+    await leave(data.redirect_to);
+    return;
+  }
+  showError(data.error || "Something went wrong (" + status + ").");
+}
+
+document.getElementById("login").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = new FormData(event.target);
+  const { status, data } = await api("POST", "/api/login", {
+    email: form.get("email"),
+    password: form.get("password"),
+  });
+  if (status !== 200) {
+    showError(data.error || "Could not log in.");
+    return;
+  }
+  showError("");
+  await loggedIn(data);
+});
+
+document.getElementById("mfa").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const code = new FormData(event.target).get("code").trim();
+  const body = { mfa_token: mfaToken };
+  if (/^\d{6}$/.test(code)) {
+    body.code = code;
+  } else {
+    body.recovery_code = code;
+  }
+  const { status, data } = await api("POST", "/api/login/mfa", body);
+  if (status !== 200) {
+    showError(data.error || "Could not verify the code.");
+    return;
+  }
+  showError("");
+  await loggedIn(data);
+});
+
+document.getElementById("approve").addEventListener("click", () => decide(true));
+document.getElementById("deny").addEventListener("click", () => decide(false));
+
+show("login");
@@ -1,28 +1,56 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
-	"sort"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Pepegakac123/chirpy/internal/auth"
 	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/throttle"
 	"github.com/google/uuid"
 )
 
+const (
+	accessTokenLifetime      = time.Hour
+	mfaTokenLifetime         = 5 * time.Minute
+	refreshTokenLifetimeDays = 60
+)
+
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Role          string    `json:"role"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+}
+
+func userResponse(user database.User) User {
+	return User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		PendingEmail:  user.PendingEmail.String,
+		IsChirpyRed:   user.IsChirpyRed,
+		Role:          user.Role,
+	}
 }
 
 type Chirp struct {
@@ -31,6 +59,22 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserId    uuid.UUID `json:"user_id"`
+	// InReplyTo is the chirp this one answers, nil for a new conversation.
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	ReplyCount int32      `json:"reply_count"`
+	LikeCount  int32      `json:"like_count"`
+	// LikedByMe is only set for authenticated callers.
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// Edited is set once the author changed the body after posting.
+	Edited bool `json:"edited"`
+}
+
+func chirpResponse(chirp database.Chirp) Chirp {
+	response := Chirp{ID: chirp.ID, CreatedAt: chirp.CreatedAt, UpdatedAt: chirp.UpdatedAt, Body: chirp.Body, UserId: chirp.UserID, ReplyCount: chirp.ReplyCount, LikeCount: chirp.LikeCount, Edited: chirp.UpdatedAt.After(chirp.CreatedAt)}
+	if chirp.InReplyTo.Valid {
+		response.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	return response
 }
 
 func checkHealth(w http.ResponseWriter, req *http.Request) {
@@ -50,12 +94,44 @@ func (c *apiConfig) handlerMetrics(w http.ResponseWriter, req *http.Request) {
 </html>`, c.fileServerHits.Load())
 }
 
+func (c *apiConfig) handlerPasswordHashReport(w http.ResponseWriter, req *http.Request) {
+	type paramsReport struct {
+		Params   string               `json:"params"`
+		Parsed   *auth.PasswordParams `json:"parsed,omitempty"`
+		Users    int64                `json:"users"`
+		Outdated bool                 `json:"outdated"`
+	}
+	rows, err := c.db.CountUsersByPasswordParams(req.Context())
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	current := auth.CurrentPasswordParams()
+	report := make([]paramsReport, 0, len(rows))
+	for _, row := range rows {
+		entry := paramsReport{Params: row.Params, Users: row.Users}
+		if parsed, err := auth.ParsePasswordParams(row.Params); err == nil {
+			entry.Parsed = &parsed
+			entry.Outdated = parsed.WeakerThan(current)
+		}
+		report = append(report, entry)
+	}
+	respondWithJSON(w, 200, map[string]any{
+		"current": current,
+		"hashes":  report,
+	})
+}
+
+func (c *apiConfig) handlerJWKS(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, c.keys.JWKS())
+}
+
 func (c *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	const defaultExpirationTime = time.Hour
 
 	var params parameters
 	w.Header().Set("Content-Type", "application/json")
@@ -66,82 +142,357 @@ func (c *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	attempt, ok := c.reserveLoginAttempt(w, req, params.Email)
+	if !ok {
+		return
+	}
 	user, err := c.db.GetUserByEmail(req.Context(), params.Email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, 401, "Incorrect email or password")
+			respondWithErrorCode(w, 401, "invalid_credentials", "Incorrect email or password")
 			return
 		}
 		respondWithError(w, 500, "Database error")
 		return
 	}
-	IsPwdOk, err := auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	IsPwdOk, err := c.verifyPassword(req.Context(), user, params.Password)
 	if err != nil {
 		respondWithError(w, 500, "Server Error")
 		return
 	}
 	if !IsPwdOk {
-		respondWithError(w, 401, "Incorrect email or password")
+		respondWithErrorCode(w, 401, "invalid_credentials", "Incorrect email or password")
+		return
+	}
+	c.releaseLoginAttempt(req, attempt)
+	c.completeLogin(w, req, user)
+}
+
+// completeLogin finishes a login once the user proved who they are with a
+// first factor. Accounts with two factor authentication get an MFA token to
+// present with their code, everybody else gets a new session.
+func (c *apiConfig) completeLogin(w http.ResponseWriter, req *http.Request, user database.User) {
+	if user.TotpEnabledAt.Valid {
+		mfaToken, err := c.keys.MakePurposeJWT(user.ID, auth.PurposeMFA, mfaTokenLifetime)
+		if err != nil {
+			respondWithError(w, 500, "Failed to create token")
+			return
+		}
+		respondWithJSON(w, 200, map[string]any{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
 		return
 	}
+	c.recordLoginSuccess(req, user.Email)
+	c.respondWithNewSession(w, req, user)
+}
 
-	token, err := auth.MakeJWT(user.ID, c.token, defaultExpirationTime)
+// verifyPassword reports whether password is the user's password. A correct
+// password whose hash is weaker than the current parameters is rehashed on
+// the way.
+func (c *apiConfig) verifyPassword(ctx context.Context, user database.User, password string) (bool, error) {
+	// Hashing it anyway would let a single request burn as much CPU as its
+	// body is large.
+	if c.passwordPolicy.TooLong(password) {
+		return false, nil
+	}
+	match, err := auth.CheckPasswordHash(password, user.HashedPassword)
 	if err != nil {
-		respondWithError(w, 500, "Failed to create token")
+		if !errors.Is(err, auth.ErrMalformedHash) {
+			return false, err
+		}
+		// Accounts without a usable hash, such as the 'unset' placeholder,
+		// can not log in with any password.
+		fmt.Printf("Unusable password hash for user %s: %v\n", user.ID, err)
+		return false, nil
+	}
+	if match {
+		c.upgradePasswordHash(ctx, user, password)
+	}
+	return match, nil
+}
+
+// upgradePasswordHash rehashes a correct password whose stored hash uses
+// weaker argon2id parameters than the current ones. Failures are only logged,
+// the old hash keeps working.
+func (c *apiConfig) upgradePasswordHash(ctx context.Context, user database.User, password string) {
+	needsRehash, err := auth.NeedsRehash(user.HashedPassword)
+	if err != nil || !needsRehash {
 		return
 	}
+	newHash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Printf("Failed to rehash password: %v\n", err)
+		return
+	}
+	// Matching on the old hash keeps a concurrent password change from being
+	// overwritten.
+	err = c.db.UpgradePasswordHash(ctx, database.UpgradePasswordHashParams{ID: user.ID, NewHash: newHash, OldHash: user.HashedPassword})
+	if err != nil {
+		fmt.Printf("Failed to store rehashed password: %v\n", err)
+	}
+}
 
-	refreshToken, err := auth.MakeRefreshToken()
+// loginAttempt is a login attempt counted against the account and the
+// client address by reserveLoginAttempt.
+type loginAttempt struct {
+	account, ip throttle.Reservation
+}
+
+// reserveLoginAttempt counts a login attempt as failed against the account
+// and the client address before the credential is checked, so parallel
+// guesses can not all get in before the first failure is recorded. It
+// responds with 429 and reports false while either is backing off. Callers
+// take the attempt back with releaseLoginAttempt once it succeeded.
+func (c *apiConfig) reserveLoginAttempt(w http.ResponseWriter, req *http.Request, email string) (loginAttempt, bool) {
+	var attempt loginAttempt
+	var wait time.Duration
+	var err error
+	attempt.ip, wait, err = c.ipThrottle.Reserve(req.Context(), "ip:"+c.clientIP(req))
 	if err != nil {
-		respondWithError(w, 500, "Failed to create refresh token")
+		respondWithError(w, 500, "Server Error")
+		return loginAttempt{}, false
+	}
+	if wait == 0 {
+		attempt.account, wait, err = c.accountThrottle.Reserve(req.Context(), accountThrottleKey(email))
+		if err != nil || wait > 0 {
+			// The attempt is not made, it must not count against the address.
+			if err := c.ipThrottle.Release(req.Context(), attempt.ip); err != nil {
+				fmt.Printf("Failed to release login attempt: %v\n", err)
+			}
+		}
+		if err != nil {
+			respondWithError(w, 500, "Server Error")
+			return loginAttempt{}, false
+		}
+	}
+	if wait > 0 {
+		respondWithTooManyAttempts(w, wait, "Too many failed login attempts, try again later")
+		return loginAttempt{}, false
+	}
+	return attempt, true
+}
+
+// respondWithTooManyAttempts responds with 429 and tells the client when to
+// try again.
+func respondWithTooManyAttempts(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithErrorCode(w, 429, "too_many_attempts", msg)
+}
+
+// releaseLoginAttempt takes back an attempt reserved by reserveLoginAttempt
+// whose credential turned out to be correct.
+func (c *apiConfig) releaseLoginAttempt(req *http.Request, attempt loginAttempt) {
+	if err := c.accountThrottle.Release(req.Context(), attempt.account); err != nil {
+		fmt.Printf("Failed to release login attempt: %v\n", err)
+	}
+	if err := c.ipThrottle.Release(req.Context(), attempt.ip); err != nil {
+		fmt.Printf("Failed to release login attempt: %v\n", err)
+	}
+}
+
+// recordLoginSuccess clears the failures of the account. The counter of the
+// client address is left alone, or an attacker could reset it by logging in
+// to an account of their own.
+func (c *apiConfig) recordLoginSuccess(req *http.Request, email string) {
+	if err := c.accountThrottle.Succeed(req.Context(), accountThrottleKey(email)); err != nil {
+		fmt.Printf("Failed to reset login failures: %v\n", err)
+	}
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// respondWithNewSession starts a new session for a fully authenticated user
+// and responds with the user and their access and refresh tokens. Logging in
+// during the grace period of an account deletion cancels the deletion.
+func (c *apiConfig) respondWithNewSession(w http.ResponseWriter, req *http.Request, user database.User) {
+	if user.DeleteAfter.Valid {
+		if _, err := c.db.CancelUserDeletion(req.Context(), user.ID); err != nil {
+			respondWithError(w, 500, "Database error")
+			return
+		}
+		user.DeleteAfter = sql.NullTime{}
+	}
+	sessionID := uuid.New()
+	token, err := c.makeAccessToken(user, sessionID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
 		return
 	}
 
-	_, err = c.db.CreateRefreshToken(req.Context(), database.CreateRefreshTokenParams{Token: refreshToken, UserID: user.ID, ExpiresAt: time.Now().UTC().AddDate(0, 0, 60)})
+	refreshToken, err := c.createRefreshToken(req, user.ID, sessionID, time.Now().UTC(), sql.NullString{}, nil)
 	if err != nil {
 		respondWithError(w, 500, "Failed to create refresh token")
 		return
 	}
-	respondWithJSON(w, 200, User{
-		ID:           user.ID,
-		CreatedAt:    user.CreatedAt,
-		UpdatedAt:    user.UpdatedAt,
-		Email:        user.Email,
-		IsChirpyRed:  user.IsChirpyRed,
-		Token:        token,
-		RefreshToken: refreshToken,
-	})
+	response := userResponse(user)
+	response.Token = token
+	response.RefreshToken = refreshToken
+	respondWithJSON(w, 200, response)
 
 }
 func (c *apiConfig) handlerRefresh(w http.ResponseWriter, req *http.Request) {
 	refreshToken, err := auth.GetBearerToken(req.Header)
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithAuthError(w, err)
 		return
 	}
-	user, err := c.db.GetUserFromRefreshToken(req.Context(), refreshToken)
+	storedToken, err := c.db.GetRefreshToken(req.Context(), c.hashToken(refreshToken))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 401, "Invalid refresh token")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	// Tokens of third party clients are refreshed at /oauth/token, where the
+	// client has to authenticate.
+	if storedToken.ClientID.Valid {
+		respondWithError(w, 401, "Invalid refresh token")
+		return
+	}
+	if !storedToken.ReplacedBy.Valid && (storedToken.RevokedAt.Valid || storedToken.ExpiresAt.Before(time.Now().UTC())) {
 		respondWithError(w, 401, "Invalid refresh token")
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(user.ID, c.token, time.Hour)
+	newRefreshToken, err := c.rotateRefreshToken(req, storedToken)
+	if err != nil {
+		if errors.Is(err, errRefreshTokenReused) {
+			respondWithError(w, 401, "Refresh token reuse detected")
+			return
+		}
+		respondWithError(w, 500, "Failed to rotate refresh token")
+		return
+	}
+	// The user is loaded again so role changes reach the new access token.
+	user, err := c.db.GetUserByID(req.Context(), storedToken.UserID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	accessToken, err := c.makeAccessToken(user, storedToken.FamilyID)
 	if err != nil {
 		respondWithError(w, 500, "Failed to create token")
 		return
 	}
 	respondWithJSON(w, 200, map[string]string{
-		"token": accessToken,
+		"token":         accessToken,
+		"refresh_token": newRefreshToken,
+	})
+
+}
+
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// rotateRefreshToken replaces a refresh token with a new one in the same
+// family. A token that was rotated before was presented again, so somebody
+// else has a copy of it; the whole family is revoked to lock them out and
+// errRefreshTokenReused is returned.
+func (c *apiConfig) rotateRefreshToken(req *http.Request, storedToken database.RefreshToken) (string, error) {
+	if storedToken.ReplacedBy.Valid {
+		return "", c.revokeReusedTokenFamily(req.Context(), storedToken.FamilyID)
+	}
+	newRefreshToken, err := c.createRefreshToken(req, storedToken.UserID, storedToken.FamilyID, storedToken.SessionStartedAt, storedToken.ClientID, storedToken.Scopes)
+	if err != nil {
+		return "", err
+	}
+	rotated, err := c.db.RotateRefreshToken(req.Context(), database.RotateRefreshTokenParams{
+		TokenHash:  storedToken.TokenHash,
+		ReplacedBy: sql.NullString{String: c.hashToken(newRefreshToken), Valid: true},
 	})
+	if err != nil {
+		return "", err
+	}
+	if rotated == 0 {
+		// A concurrent request rotated the same token first.
+		return "", c.revokeReusedTokenFamily(req.Context(), storedToken.FamilyID)
+	}
+	return newRefreshToken, nil
+}
 
+func (c *apiConfig) revokeReusedTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	if err := c.db.RevokeTokenFamily(ctx, familyID); err != nil {
+		return err
+	}
+	return errRefreshTokenReused
 }
+
+// createRefreshToken stores a new refresh token in the given token family.
+// Logins start a new family, rotations continue the family of the old token.
+// The family doubles as the session, so the device metadata is taken from
+// the request that created or last rotated it. Tokens of third party clients
+// carry the client and the scopes it was granted.
+func (c *apiConfig) createRefreshToken(req *http.Request, userID, familyID uuid.UUID, sessionStartedAt time.Time, clientID sql.NullString, scopes []string) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = c.db.CreateRefreshToken(req.Context(), database.CreateRefreshTokenParams{
+		TokenHash:        c.hashToken(refreshToken),
+		UserID:           userID,
+		ExpiresAt:        time.Now().UTC().AddDate(0, 0, refreshTokenLifetimeDays),
+		FamilyID:         familyID,
+		UserAgent:        req.UserAgent(),
+		IpAddress:        c.clientIP(req),
+		SessionStartedAt: sessionStartedAt,
+		ClientID:         clientID,
+		Scopes:           scopes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// hashToken returns the hash an opaque secret such as a refresh token or a
+// recovery code is stored and looked up under.
+func (c *apiConfig) hashToken(token string) string {
+	return auth.HashToken(token, c.tokenPepper)
+}
+
+// makeAccessToken issues an access token bound to the session it belongs to.
+func (c *apiConfig) makeAccessToken(user database.User, sessionID uuid.UUID) (string, error) {
+	claims := auth.NewClaims(user.ID, accessTokenLifetime)
+	claims.SessionID = sessionID.String()
+	claims.Role = auth.Role(user.Role)
+	return c.keys.Sign(claims)
+}
+
+// clientIP returns the address of the client, honouring X-Forwarded-For only
+// when the server is configured to run behind trusted proxies. Clients can
+// send any X-Forwarded-For they like, so only the entries appended by our own
+// proxies, counted from the right, are believed.
+func (c *apiConfig) clientIP(req *http.Request) string {
+	if c.trustedProxies > 0 {
+		var forwarded []string
+		for _, header := range req.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+		if len(forwarded) > 0 {
+			return forwarded[max(len(forwarded)-c.trustedProxies, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
 func (c *apiConfig) handlerRevoke(w http.ResponseWriter, req *http.Request) {
 	refreshToken, err := auth.GetBearerToken(req.Header)
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithAuthError(w, err)
 		return
 	}
-	err = c.db.RevokeToken(req.Context(), refreshToken)
+	err = c.db.RevokeToken(req.Context(), c.hashToken(refreshToken))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondWithError(w, 401, "Invalid refresh token")
@@ -153,6 +504,8 @@ func (c *apiConfig) handlerRevoke(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(204)
 }
 func (c *apiConfig) handlerReset(w http.ResponseWriter, req *http.Request) {
+	// Wiping every table is only meant for local test runs, so even admins
+	// can not do it outside of dev.
 	if c.platform != "dev" {
 		respondWithError(w, 403, "You can not reset user anywhere else than in dev PLATFORM")
 		return
@@ -174,7 +527,8 @@ func (c *apiConfig) handlerReset(w http.ResponseWriter, req *http.Request) {
 }
 func (c *apiConfig) handlerCreateChirps(w http.ResponseWriter, req *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
 	}
 	var params parameters
 	w.Header().Set("Content-Type", "application/json")
@@ -185,14 +539,9 @@ func (c *apiConfig) handlerCreateChirps(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	bearerToken, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
-		return
-	}
-	userId, err := auth.ValidateJWT(bearerToken, c.token)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+	user := principalFrom(req.Context()).User
+	if c.requireVerifiedEmail && !user.EmailVerifiedAt.Valid {
+		respondWithError(w, 403, "Verify your email address before posting chirps")
 		return
 	}
 	cleanedBody, err := validateChirp(params.Body)
@@ -202,57 +551,124 @@ func (c *apiConfig) handlerCreateChirps(w http.ResponseWriter, req *http.Request
 	}
 	arg := database.CreateChirpParams{
 		Body:   cleanedBody,
-		UserID: userId,
+		UserID: user.ID,
 	}
-	chirp, err := c.db.CreateChirp(req.Context(), arg)
+	errNoParent := errors.New("parent chirp not found")
+	var chirp database.Chirp
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		if params.InReplyTo != nil {
+			// Deleted chirps cannot be replied to, only their existing
+			// replies stay. The lock keeps the parent from being deleted
+			// before the reply is in. A key share lock does not block the
+			// reply count trigger of concurrent replies, a share lock would.
+			parent, err := q.GetChirpForKeyShare(req.Context(), *params.InReplyTo)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return errNoParent
+				}
+				return err
+			}
+			arg.InReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		}
+		chirp, err = q.CreateChirp(req.Context(), arg)
+		return err
+	})
 	if err != nil {
+		if errors.Is(err, errNoParent) {
+			respondWithError(w, 400, "The chirp to reply to does not exist")
+			return
+		}
 		respondWithError(w, 500, "Something went wrong creating chirp")
 		return
 	}
-	respondWithJSON(w, 201, Chirp{ID: chirp.ID, CreatedAt: chirp.CreatedAt, UpdatedAt: chirp.UpdatedAt, Body: chirp.Body, UserId: chirp.UserID})
+	response := chirpResponse(chirp)
+	if err := c.setLikedByMe(req.Context(), &response); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 201, response)
 }
 
+// handlerGetChirps responds with one page of chirps ordered by creation time.
+//
+// The body stays a plain array so existing clients keep working, paging
+// lives in the RFC 8288 Link header instead: rel="next" and rel="prev" are
+// absolute URLs of the neighbouring pages, left out at either end of the
+// list. Clients follow them as they are, the cursors in them are opaque.
+// Every paged list endpoint follows this contract.
 func (c *apiConfig) handlerGetChirps(w http.ResponseWriter, req *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
-	queryAuthorId := req.URL.Query().Get("author_id")
-	querySort := req.URL.Query().Get("sort")
-	var chirp []database.Chirp
-	if queryAuthorId == "" {
-		dbChirp, err := c.db.GetAllChirps(req.Context())
-		if err != nil {
-			respondWithError(w, 500, "Something went wrong creating chirp")
-			return
-		}
-		chirp = append(chirp, dbChirp...)
-	} else {
-		authorID, err := uuid.Parse(queryAuthorId)
-		if err != nil {
-			respondWithError(w, 400, "Invalid author ID")
-			return
-		}
-		dbChirp, err := c.db.GetAllChirpsByAuthor(req.Context(), authorID)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				respondWithError(w, 404, "Chirp not found")
-				return
-			}
-			respondWithError(w, 500, "Something went wrong creating chirp")
-			return
-		}
-		chirp = append(chirp, dbChirp...)
+	query := req.URL.Query()
+	page, err := parsePageRequest(query)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
 	}
-	if querySort != "desc" {
-		sort.Slice(chirp, func(i, j int) bool {
-			return chirp[i].CreatedAt.Before(chirp[j].CreatedAt)
-		})
+	querySort := query.Get("sort")
+	if querySort != "" && querySort != "asc" && querySort != "desc" {
+		respondWithError(w, 400, "Sort must be asc or desc")
+		return
+	}
+	authorID, err := parseAuthorFilter(query)
+	if err != nil {
+		respondWithError(w, 400, "Invalid author ID")
+		return
+	}
+
+	// Paging backwards scans in the opposite order and flips the rows, so
+	// every page is a single index range scan.
+	cursor, backwards := page.after, false
+	if page.before != nil {
+		cursor, backwards = page.before, true
+	}
+	arg := database.ListChirpsAscendingParams{
+		AuthorID: authorID,
+		MaxRows:  page.maxRows(),
+	}
+	if cursor != nil {
+		arg.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		arg.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+	var chirps []database.Chirp
+	if (querySort == "desc") != backwards {
+		chirps, err = c.db.ListChirpsDescending(req.Context(), database.ListChirpsDescendingParams(arg))
 	} else {
-		sort.Slice(chirp, func(i, j int) bool {
-			return chirp[i].CreatedAt.After(chirp[j].CreatedAt)
-		})
+		chirps, err = c.db.ListChirpsAscending(req.Context(), arg)
+	}
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	chirps = setPage(c, w, req, page, chirps, func(chirp database.Chirp) pageCursor {
+		return pageCursor{CreatedAt: chirp.CreatedAt, ID: chirp.ID}
+	})
+	response := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		response = append(response, chirpResponse(chirp))
+	}
+	likeable := make([]*Chirp, 0, len(response))
+	for i := range response {
+		likeable = append(likeable, &response[i])
+	}
+	if err := c.setLikedByMe(req.Context(), likeable...); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
 	}
+	respondWithJSON(w, 200, response)
+}
 
-	respondWithJSON(w, 200, chirp)
+// parseAuthorFilter returns the author_id query parameter, which is optional.
+func parseAuthorFilter(query url.Values) (uuid.NullUUID, error) {
+	value := query.Get("author_id")
+	if value == "" {
+		return uuid.NullUUID{}, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, nil
 }
 func (c *apiConfig) handlerGetSingleChirp(w http.ResponseWriter, req *http.Request) {
 
@@ -272,47 +688,61 @@ func (c *apiConfig) handlerGetSingleChirp(w http.ResponseWriter, req *http.Reque
 		respondWithError(w, 500, "Database error")
 		return
 	}
-	respondWithJSON(w, 200, chirp)
+	response := chirpResponse(chirp)
+	if err := c.setLikedByMe(req.Context(), &response); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, response)
 }
 
 func (c *apiConfig) handlerDeleteSingleChirp(w http.ResponseWriter, req *http.Request) {
-	bearerToken, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(w, 401, "Unauthenticated")
-		return
-	}
-	userID, err := auth.ValidateJWT(bearerToken, c.token)
-	if err != nil {
-		respondWithError(w, 401, "Unauthenticated")
-		return
-	}
+	user := principalFrom(req.Context()).User
 	chirpIDString := req.PathValue("chirpID")
 	chirpID, err := uuid.Parse(chirpIDString)
 	if err != nil {
 		respondWithError(w, 400, "Invalid chirp ID")
 		return
 	}
-	chirp, err := c.db.GetSingleChirp(req.Context(), chirpID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, 404, "Chirp not found")
-			return
+	errNotFound := errors.New("chirp not found")
+	errForbidden := errors.New("not allowed to delete the chirp")
+	// A chirp that was replied to becomes a tombstone, so its replies keep
+	// their place in the thread.
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		// The row lock waits for replies being posted and keeps new ones
+		// out until the chirp is gone.
+		chirp, err := q.GetChirpForUpdate(req.Context(), chirpID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errNotFound
+			}
+			return err
 		}
-		respondWithError(w, 500, "Database error")
-		return
-	}
-	if chirp.UserID != userID {
-		respondWithError(w, 403, "Forbidden")
-		return
-	}
-	err = c.db.DeleteChirpByID(req.Context(), chirpID)
+		// Moderators can remove anybody's chirps.
+		if chirp.UserID != user.ID && !auth.Role(user.Role).Can(auth.PermissionModerateChirps) {
+			return errForbidden
+		}
+		hasReplies, err := q.ChirpHasReplies(req.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+		if err != nil {
+			return err
+		}
+		if !hasReplies {
+			return q.DeleteChirpByID(req.Context(), chirpID)
+		}
+		if err := q.DeleteChirpRevisions(req.Context(), chirpID); err != nil {
+			return err
+		}
+		return q.TombstoneChirp(req.Context(), chirpID)
+	})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, 404, "Not Found")
-			return
+		switch {
+		case errors.Is(err, errNotFound):
+			respondWithError(w, 404, "Chirp not found")
+		case errors.Is(err, errForbidden):
+			respondWithError(w, 403, "Forbidden")
+		default:
+			respondWithError(w, 500, "Database error")
 		}
-
-		respondWithError(w, 500, "Database error")
 		return
 	}
 	w.WriteHeader(204)
@@ -331,6 +761,15 @@ func (c *apiConfig) handlerUsers(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
+	fields := fieldErrors{}
+	if err := validateEmail(params.Email); err != nil {
+		fields.add("email", "invalid", err.Error())
+	}
+	c.checkPassword(fields, params.Password, params.Email)
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, fields)
+		return
+	}
 	hashedPwd, err := auth.HashPassword(params.Password)
 	if err != nil {
 		respondWithError(w, 500, "Something went wrong")
@@ -342,47 +781,130 @@ func (c *apiConfig) handlerUsers(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, "Something went wrong whe connecting to the database")
 		return
 	}
-	respondWithJSON(w, 201, User{ID: user.ID, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt, Email: user.Email, IsChirpyRed: user.IsChirpyRed})
+	err = c.sendEmailVerification(req.Context(), user.ID, user.Email)
+	if err != nil {
+		fmt.Printf("Failed to send verification email: %v\n", err)
+	}
+	respondWithJSON(w, 201, userResponse(user))
 
 }
 
+// handlerUpdateUsers changes only the fields the request contains. Changing
+// the email or the password needs the current password, so a stolen token
+// is not enough to take over the account.
 func (c *apiConfig) handlerUpdateUsers(w http.ResponseWriter, req *http.Request) {
-	bearerToken, err := auth.GetBearerToken(req.Header)
+	principal := principalFrom(req.Context())
+	user := principal.User
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	var params parameters
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithError(w, 400, "Invalid request body")
 		return
 	}
-	userId, err := auth.ValidateJWT(bearerToken, c.token)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+	changeEmail := params.Email != nil && *params.Email != user.Email
+	changePassword := params.Password != nil
+	if !changeEmail && !changePassword {
+		respondWithJSON(w, 200, userResponse(user))
 		return
 	}
-	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+	if principal.Impersonated() {
+		respondWithImpersonationForbidden(w)
+		return
 	}
-	var params parameters
 
-	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, 500, "Something went wrong")
+	fields := fieldErrors{}
+	if changeEmail {
+		if err := validateEmail(*params.Email); err != nil {
+			fields.add("email", "invalid", err.Error())
+		}
+	}
+	if changePassword {
+		emails := []string{user.Email}
+		if changeEmail {
+			emails = append(emails, *params.Email)
+		}
+		c.checkPassword(fields, *params.Password, emails...)
+	}
+	if params.CurrentPassword == "" {
+		fields.add("current_password", "required", "Current password is required to change the email or password")
+	}
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, fields)
 		return
 	}
-	hashedPwd, err := auth.HashPassword(params.Password)
-	if err != nil {
-		respondWithError(w, 500, "Something went wrong")
+	if !c.checkCurrentPassword(w, req, user, params.CurrentPassword) {
 		return
 	}
-	updatedUser, err := c.db.UpdateUserData(req.Context(), database.UpdateUserDataParams{Email: params.Email, HashedPassword: hashedPwd, ID: userId})
+
+	if changeEmail {
+		// The new address only replaces the old one once it is verified.
+		if !c.requestEmailChange(w, req, user, *params.Email) {
+			return
+		}
+	}
+	if changePassword {
+		hashedPwd, err := auth.HashPassword(*params.Password)
+		if err != nil {
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		err = c.withTx(req.Context(), func(q *database.Queries) error {
+			err := q.UpdateUserPassword(req.Context(), database.UpdateUserPasswordParams{ID: user.ID, HashedPassword: hashedPwd})
+			if err != nil {
+				return err
+			}
+			err = q.InvalidatePasswordResetTokens(req.Context(), user.ID)
+			if err != nil {
+				return err
+			}
+			// Every other device has to log in with the new password, the
+			// one making the change stays logged in.
+			return q.RevokeOtherUserTokens(req.Context(), database.RevokeOtherUserTokensParams{UserID: user.ID, FamilyID: principal.SessionID})
+		})
+		if err != nil {
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+	updatedUser, err := c.db.GetUserByID(req.Context(), user.ID)
 	if err != nil {
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	respondWithJSON(w, 200, User{ID: updatedUser.ID, CreatedAt: updatedUser.CreatedAt, UpdatedAt: updatedUser.UpdatedAt, Email: updatedUser.Email, IsChirpyRed: updatedUser.IsChirpyRed})
+	respondWithJSON(w, 200, userResponse(updatedUser))
 
 }
 
+// checkCurrentPassword responds with an error and reports false unless
+// password is the user's password. Wrong guesses count against the login
+// throttle like failed logins do.
+func (c *apiConfig) checkCurrentPassword(w http.ResponseWriter, req *http.Request, user database.User, password string) bool {
+	attempt, ok := c.reserveLoginAttempt(w, req, user.Email)
+	if !ok {
+		return false
+	}
+	match, err := c.verifyPassword(req.Context(), user, password)
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return false
+	}
+	// Accounts created through a login provider have no password to
+	// confirm with, they set one through a password reset first.
+	if !match {
+		respondWithErrorCode(w, 403, "invalid_current_password", "Current password is incorrect")
+		return false
+	}
+	c.releaseLoginAttempt(req, attempt)
+	return true
+}
+
 func (c *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, req *http.Request) {
 	type parameters struct {
 		Event string `json:"event"`
@@ -392,8 +914,12 @@ func (c *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, req *http.Request
 	}
 
 	reqApiKey, err := auth.GetApiKey(req.Header)
-	if err != nil || reqApiKey != c.polkaApiKey {
-		respondWithError(w, 401, "The api key does not match")
+	if err != nil {
+		respondWithErrorCode(w, 401, "missing_credentials", "Missing api key")
+		return
+	}
+	if reqApiKey != c.polkaApiKey {
+		respondWithErrorCode(w, 401, "invalid_credentials", "The api key does not match")
 		return
 	}
 
@@ -436,6 +962,74 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
 	respondWithJSON(w, code, map[string]string{"error": msg})
 }
 
+// respondWithErrorCode adds a machine readable code next to the message, so
+// clients can tell apart errors that share a status.
+func respondWithErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	respondWithJSON(w, status, map[string]string{"error": msg, "code": code})
+}
+
+// fieldError is one problem with one field of a request body.
+type fieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// fieldErrors collects the problems of a request body by field, so clients
+// can show each one next to its input.
+type fieldErrors map[string][]fieldError
+
+func (f fieldErrors) add(field, code, message string) {
+	for _, existing := range f[field] {
+		if existing.Code == code {
+			return
+		}
+	}
+	f[field] = append(f[field], fieldError{Code: code, Message: message})
+}
+
+// checkPassword adds the password policy violations of a new password. The
+// password may not match any of the emails of the account.
+func (c *apiConfig) checkPassword(f fieldErrors, password string, emails ...string) {
+	if len(emails) == 0 {
+		emails = []string{""}
+	}
+	for _, email := range emails {
+		for _, v := range c.passwordPolicy.Validate(password, email) {
+			f.add("password", v.Code, v.Message)
+		}
+	}
+}
+
+func respondWithFieldErrors(w http.ResponseWriter, f fieldErrors) {
+	respondWithJSON(w, 422, map[string]any{
+		"error":  "Invalid request",
+		"code":   "validation_failed",
+		"fields": f,
+	})
+}
+
+// respondWithAuthError translates the errors of the auth package into the
+// matching response. Anything it does not recognise is a server error.
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMissingAuthHeader):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+		respondWithErrorCode(w, 401, "missing_credentials", "Missing bearer token")
+	case errors.Is(err, auth.ErrTokenExpired):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="invalid_token", error_description="The token has expired"`)
+		respondWithErrorCode(w, 401, "token_expired", "Token has expired")
+	case errors.Is(err, auth.ErrTokenInvalid):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="invalid_token"`)
+		respondWithErrorCode(w, 401, "token_invalid", "Invalid token")
+	case errors.Is(err, auth.ErrInsufficientScope):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="insufficient_scope"`)
+		respondWithErrorCode(w, 403, "insufficient_scope", "Token does not allow this action")
+	default:
+		fmt.Printf("Unexpected auth error: %v\n", err)
+		respondWithErrorCode(w, 500, "internal_error", "Server Error")
+	}
+}
+
 func replaceBadWords(msg string, badWords map[string]string) string {
 	splitedString := strings.Split(msg, " ")
 	for i, word := range splitedString {
@@ -446,6 +1040,14 @@ func replaceBadWords(msg string, badWords map[string]string) string {
 	return strings.Join(splitedString, " ")
 }
 
+func validateEmail(email string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("Invalid email address")
+	}
+	return nil
+}
+
 func validateChirp(body string) (string, error) {
 	replacement := "****"
 	badWords := map[string]string{
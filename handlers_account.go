@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/mail"
+	"github.com/google/uuid"
+)
+
+// handlerDeleteAccount deletes the caller's account after the configured
+// grace period. The account is logged out everywhere right away, logging in
+// again before the period ends cancels the deletion.
+func (c *apiConfig) handlerDeleteAccount(w http.ResponseWriter, req *http.Request) {
+	user := principalFrom(req.Context()).User
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if params.Password == "" {
+		fields := fieldErrors{}
+		fields.add("password", "required", "Password is required to delete the account")
+		respondWithFieldErrors(w, fields)
+		return
+	}
+	if !c.checkCurrentPassword(w, req, user, params.Password) {
+		return
+	}
+
+	if c.accountDeletionGrace == 0 {
+		// Chirps, tokens and everything else of the user cascade.
+		if err := c.db.DeleteUser(req.Context(), user.ID); err != nil {
+			respondWithError(w, 500, "Database error")
+			return
+		}
+		w.WriteHeader(204)
+		return
+	}
+	deleteAfter := time.Now().UTC().Add(c.accountDeletionGrace)
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		err := q.ScheduleUserDeletion(req.Context(), database.ScheduleUserDeletionParams{
+			ID:          user.ID,
+			DeleteAfter: sql.NullTime{Time: deleteAfter, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return q.RevokeAllUserTokens(req.Context(), user.ID)
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	err = c.mailer.Send(req.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf("Your Chirpy account and all of its chirps will be deleted on %s.\n\n"+
+			"If you change your mind, log in before then and the deletion is cancelled.\n",
+			deleteAfter.Format("January 2, 2006 15:04 MST")),
+	})
+	if err != nil {
+		fmt.Printf("Failed to send account deletion email: %v\n", err)
+	}
+	respondWithJSON(w, 202, map[string]time.Time{"delete_after": deleteAfter})
+}
+
+// purgeDeletedAccounts deletes the accounts whose grace period ended, every
+// interval until ctx is done.
+func (c *apiConfig) purgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := c.db.PurgeDeletedUsers(ctx)
+		if err != nil {
+			fmt.Printf("Failed to purge deleted accounts: %v\n", err)
+		} else if deleted > 0 {
+			fmt.Printf("Purged %d deleted accounts\n", deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// accountExport is everything Chirpy stores about a user that is theirs to
+// take along. Secrets such as password hashes and tokens are left out.
+type accountExport struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Profile    exportedProfile   `json:"profile"`
+	Chirps     []Chirp           `json:"chirps"`
+	Sessions   []exportedSession `json:"sessions"`
+}
+
+type exportedProfile struct {
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    string     `json:"pending_email,omitempty"`
+	IsChirpyRed     bool       `json:"is_chirpy_red"`
+	Role            string     `json:"role"`
+	MFAEnabled      bool       `json:"mfa_enabled"`
+}
+
+type exportedSession struct {
+	ID         uuid.UUID  `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	StartedAt  time.Time  `json:"started_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	EndedAt    *time.Time `json:"ended_at"`
+	ClientID   string     `json:"client_id,omitempty"`
+}
+
+// handlerExportAccount responds with a copy of the caller's data, a ZIP
+// archive of JSON files by default or a single JSON document with
+// ?format=json.
+func (c *apiConfig) handlerExportAccount(w http.ResponseWriter, req *http.Request) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		respondWithError(w, 400, "Format must be zip or json")
+		return
+	}
+	export, err := c.exportAccount(req.Context(), principalFrom(req.Context()).User)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	filename := "chirpy-export-" + export.ExportedAt.Format("2006-01-02")
+	if format == "json" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		respondWithJSON(w, 200, export)
+		return
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", export.Profile},
+		{"chirps.json", export.Chirps},
+		{"sessions.json", export.Sessions},
+	}
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err == nil {
+			encoder := json.NewEncoder(f)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(file.content)
+		}
+		if err != nil {
+			respondWithError(w, 500, "Failed to create the archive")
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		respondWithError(w, 500, "Failed to create the archive")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
+func (c *apiConfig) exportAccount(ctx context.Context, user database.User) (*accountExport, error) {
+	export := &accountExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: exportedProfile{
+			ID:           user.ID,
+			CreatedAt:    user.CreatedAt,
+			UpdatedAt:    user.UpdatedAt,
+			Email:        user.Email,
+			PendingEmail: user.PendingEmail.String,
+			IsChirpyRed:  user.IsChirpyRed,
+			Role:         user.Role,
+			MFAEnabled:   user.TotpEnabledAt.Valid,
+		},
+		Chirps:   []Chirp{},
+		Sessions: []exportedSession{},
+	}
+	if user.EmailVerifiedAt.Valid {
+		export.Profile.EmailVerifiedAt = &user.EmailVerifiedAt.Time
+	}
+
+	chirps, err := c.db.GetAllChirpsByAuthor(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, chirp := range chirps {
+		export.Chirps = append(export.Chirps, chirpResponse(chirp))
+	}
+
+	sessions, err := c.db.ListSessionHistory(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range sessions {
+		session := exportedSession{
+			ID:         row.FamilyID,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			StartedAt:  row.SessionStartedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			ClientID:   row.ClientID.String,
+		}
+		if row.RevokedAt.Valid {
+			session.EndedAt = &row.RevokedAt.Time
+		}
+		export.Sessions = append(export.Sessions, session)
+	}
+	return export, nil
+}
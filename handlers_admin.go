@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerSetUserRole changes the role of a user. The user's current access
+// tokens keep their old role until they are refreshed.
+func (c *apiConfig) handlerSetUserRole(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user ID")
+		return
+	}
+	user, err := c.db.SetUserRole(req.Context(), database.SetUserRoleParams{ID: userID, Role: string(role)})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "User not found")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, userResponse(user))
+}
+
+// impersonationTokenLifetime is short on purpose, impersonation tokens can
+// not be refreshed.
+const impersonationTokenLifetime = 15 * time.Minute
+
+// Actions recorded in the audit log.
+const (
+	auditImpersonationStarted = "impersonation.started"
+	auditImpersonatedRequest  = "impersonation.request"
+)
+
+// handlerImpersonateUser gives an admin a short lived access token of
+// another user, so support can see what the user sees. The token names the
+// admin in its act claim and every request made with it is audited.
+func (c *apiConfig) handlerImpersonateUser(w http.ResponseWriter, req *http.Request) {
+	admin := principalFrom(req.Context()).User
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" {
+		fields := fieldErrors{}
+		fields.add("reason", "required", "Say why you need to impersonate the user")
+		respondWithFieldErrors(w, fields)
+		return
+	}
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user ID")
+		return
+	}
+	if userID == admin.ID {
+		respondWithError(w, 400, "You can not impersonate yourself")
+		return
+	}
+	user, err := c.db.GetUserByID(req.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "User not found")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	// An admin acting as another admin would leave that admin's name on
+	// their actions.
+	if auth.Role(user.Role).Can(auth.PermissionImpersonate) {
+		respondWithErrorCode(w, 403, "forbidden", "Admins can not be impersonated")
+		return
+	}
+
+	claims := auth.NewClaims(user.ID, impersonationTokenLifetime)
+	claims.Role = auth.Role(user.Role)
+	claims.Actor = &auth.Actor{Subject: admin.ID.String()}
+	token, err := c.keys.Sign(claims)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	err = c.db.CreateAuditLogEntry(req.Context(), database.CreateAuditLogEntryParams{
+		ActorID: admin.ID,
+		UserID:  user.ID,
+		Action:  auditImpersonationStarted,
+		Detail:  params.Reason,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 201, map[string]any{
+		"token":        token,
+		"expires_at":   claims.ExpiresAt.Time,
+		"impersonated": userResponse(user),
+	})
+}
+
+// AuditLogEntry is one recorded action of an admin on a user.
+type AuditLogEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   uuid.UUID `json:"actor_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail"`
+}
+
+// handlerListAuditLog responds with the newest audit log entries, 100 unless
+// ?limit asks for up to 1000.
+func (c *apiConfig) handlerListAuditLog(w http.ResponseWriter, req *http.Request) {
+	limit := 100
+	if value := req.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 1000 {
+			respondWithError(w, 400, "Limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+	rows, err := c.db.ListAuditLog(req.Context(), int32(limit))
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	entries := make([]AuditLogEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, AuditLogEntry{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			ActorID:   row.ActorID,
+			UserID:    row.UserID,
+			Action:    row.Action,
+			Detail:    row.Detail,
+		})
+	}
+	respondWithJSON(w, 200, entries)
+}
+
+// promoteAdmin gives the account with the given email the admin role, so a
+// fresh deployment has somebody who can hand out roles.
+func promoteAdmin(ctx context.Context, db *database.Queries, email string) error {
+	user, err := db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("ADMIN_EMAIL %s does not belong to any user", email)
+		}
+		return err
+	}
+	if user.Role == string(auth.RoleAdmin) {
+		return nil
+	}
+	_, err = db.SetUserRole(ctx, database.SetUserRoleParams{ID: user.ID, Role: string(auth.RoleAdmin)})
+	return err
+}
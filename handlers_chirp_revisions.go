@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// ChirpRevision is a body a chirp had before it was edited.
+type ChirpRevision struct {
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// handlerUpdateChirp changes the body of one of the caller's chirps. It is
+// only possible for chirpEditWindow after posting, the previous body is kept
+// as a revision.
+func (c *apiConfig) handlerUpdateChirp(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	cleanedBody, err := validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	user := principalFrom(req.Context()).User
+	errNotFound := errors.New("chirp not found")
+	errNotAuthor := errors.New("not the author")
+	errWindowClosed := errors.New("edit window closed")
+	var updated database.Chirp
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		// The row lock keeps two edits from saving the same revision.
+		chirp, err := q.GetChirpForUpdate(req.Context(), chirpID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errNotFound
+			}
+			return err
+		}
+		if chirp.UserID != user.ID {
+			return errNotAuthor
+		}
+		if time.Since(chirp.CreatedAt) > c.chirpEditWindow {
+			return errWindowClosed
+		}
+		if chirp.Body == cleanedBody {
+			updated = chirp
+			return nil
+		}
+		err = q.CreateChirpRevision(req.Context(), database.CreateChirpRevisionParams{
+			ChirpID:   chirp.ID,
+			Body:      chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		updated, err = q.UpdateChirpBody(req.Context(), database.UpdateChirpBodyParams{ID: chirp.ID, Body: cleanedBody})
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotFound):
+			respondWithError(w, 404, "Chirp not found")
+		case errors.Is(err, errNotAuthor):
+			respondWithError(w, 403, "Forbidden")
+		case errors.Is(err, errWindowClosed):
+			respondWithErrorCode(w, 403, "edit_window_closed",
+				fmt.Sprintf("Chirps can only be edited for %d minutes after posting", int(c.chirpEditWindow.Minutes())))
+		default:
+			respondWithError(w, 500, "Database error")
+		}
+		return
+	}
+	response := chirpResponse(updated)
+	if err := c.setLikedByMe(req.Context(), &response); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, response)
+}
+
+// handlerListChirpRevisions responds with the earlier bodies of a chirp, the
+// most recent first. Chirps are public, so is their history.
+func (c *apiConfig) handlerListChirpRevisions(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+	_, err = c.db.GetSingleChirp(req.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	rows, err := c.db.ListChirpRevisions(req.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	revisions := make([]ChirpRevision, 0, len(rows))
+	for _, row := range rows {
+		revisions = append(revisions, ChirpRevision{Body: row.Body, CreatedAt: row.CreatedAt, ReplacedAt: row.ReplacedAt})
+	}
+	respondWithJSON(w, 200, revisions)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/mail"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const emailVerificationTokenLifetime = 24 * time.Hour
+
+func (c *apiConfig) handlerVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	verified, err := c.db.ConsumeEmailVerificationToken(req.Context(), c.hashToken(params.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 400, "Invalid or expired verification token")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	user, err := c.db.ConfirmUserEmail(req.Context(), database.ConfirmUserEmailParams{ID: verified.UserID, Email: verified.Email})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The account moved on to another address since the mail was sent.
+			respondWithError(w, 400, "Invalid or expired verification token")
+			return
+		}
+		if isUniqueViolation(err) {
+			respondWithError(w, 409, "Email already in use")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, userResponse(user))
+}
+
+func (c *apiConfig) handlerResendEmailVerification(w http.ResponseWriter, req *http.Request) {
+	user := principalFrom(req.Context()).User
+	email := user.Email
+	if user.PendingEmail.Valid {
+		email = user.PendingEmail.String
+	} else if user.EmailVerifiedAt.Valid {
+		respondWithError(w, 409, "Email address is already verified")
+		return
+	}
+	err := c.sendEmailVerification(req.Context(), user.ID, email)
+	if err != nil {
+		respondWithError(w, 500, "Failed to send verification email")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// requestEmailChange records newEmail as pending and mails a verification
+// token to it. It writes the error response itself and reports whether the
+// caller can carry on.
+func (c *apiConfig) requestEmailChange(w http.ResponseWriter, req *http.Request, user database.User, newEmail string) bool {
+	err := validateEmail(newEmail)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return false
+	}
+	_, err = c.db.GetUserByEmail(req.Context(), newEmail)
+	if err == nil {
+		respondWithError(w, 409, "Email already in use")
+		return false
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 500, "Database error")
+		return false
+	}
+	err = c.db.SetPendingEmail(req.Context(), database.SetPendingEmailParams{ID: user.ID, PendingEmail: sql.NullString{String: newEmail, Valid: true}})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return false
+	}
+	err = c.sendEmailVerification(req.Context(), user.ID, newEmail)
+	if err != nil {
+		respondWithError(w, 500, "Failed to send verification email")
+		return false
+	}
+	return true
+}
+
+func (c *apiConfig) sendEmailVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = c.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: c.hashToken(token),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().UTC().Add(emailVerificationTokenLifetime),
+	})
+	if err != nil {
+		return err
+	}
+	link := c.baseURL + "/app/verify-email?token=" + url.QueryEscape(token)
+	return c.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Open %s within the next 24 hours to verify this email address.\n\n"+
+			"Your verification token is %s\n\n"+
+			"If you did not sign up for Chirpy, you can ignore this email.\n", link, token),
+	})
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
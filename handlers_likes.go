@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Liker is a user who liked a chirp.
+type Liker struct {
+	UserID  uuid.UUID `json:"user_id"`
+	LikedAt time.Time `json:"liked_at"`
+}
+
+// handlerLikeChirp likes a chirp for the caller and responds with the chirp.
+// Liking a chirp twice is not an error.
+func (c *apiConfig) handlerLikeChirp(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+	_, err = c.db.LikeChirp(req.Context(), database.LikeChirpParams{
+		UserID:  principalFrom(req.Context()).User.ID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	c.respondWithLikedChirp(w, req, chirpID)
+}
+
+// handlerUnlikeChirp takes back the caller's like and responds with the
+// chirp. Chirps the caller did not like are left as they are.
+func (c *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+	_, err = c.db.UnlikeChirp(req.Context(), database.UnlikeChirpParams{
+		ChirpID: chirpID,
+		UserID:  principalFrom(req.Context()).User.ID,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	c.respondWithLikedChirp(w, req, chirpID)
+}
+
+func (c *apiConfig) respondWithLikedChirp(w http.ResponseWriter, req *http.Request, chirpID uuid.UUID) {
+	chirp, err := c.db.GetSingleChirp(req.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	response := chirpResponse(chirp)
+	if err := c.setLikedByMe(req.Context(), &response); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, response)
+}
+
+// handlerListChirpLikes responds with one page of the users who liked a
+// chirp, in the order they liked it.
+func (c *apiConfig) handlerListChirpLikes(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+	page, err := parseForwardPageRequest(req.URL.Query(), "Likes")
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	_, err = c.db.GetSingleChirp(req.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	arg := database.ListChirpLikesParams{
+		ChirpID: chirpID,
+		MaxRows: page.maxRows(),
+	}
+	if page.after != nil {
+		arg.CursorCreatedAt = sql.NullTime{Time: page.after.CreatedAt, Valid: true}
+		arg.CursorUserID = uuid.NullUUID{UUID: page.after.ID, Valid: true}
+	}
+	likes, err := c.db.ListChirpLikes(req.Context(), arg)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	likes = setPage(c, w, req, page, likes, func(like database.ChirpLike) pageCursor {
+		return pageCursor{CreatedAt: like.CreatedAt, ID: like.UserID}
+	})
+	likers := make([]Liker, 0, len(likes))
+	for _, like := range likes {
+		likers = append(likers, Liker{UserID: like.UserID, LikedAt: like.CreatedAt})
+	}
+	respondWithJSON(w, 200, likers)
+}
+
+// setLikedByMe fills in LikedByMe when the caller is authenticated and may
+// read chirps. Responses to anonymous callers leave it out.
+func (c *apiConfig) setLikedByMe(ctx context.Context, chirps ...*Chirp) error {
+	p := principalFrom(ctx)
+	if p == nil || !p.HasScope(auth.ScopeChirpsRead) || len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	liked, err := c.db.ListLikedChirpIDs(ctx, database.ListLikedChirpIDsParams{UserID: p.User.ID, ChirpIds: ids})
+	if err != nil {
+		return err
+	}
+	likedIDs := make(map[uuid.UUID]bool, len(liked))
+	for _, id := range liked {
+		likedIDs[id] = true
+	}
+	for _, chirp := range chirps {
+		likedByMe := likedIDs[chirp.ID]
+		chirp.LikedByMe = &likedByMe
+	}
+	return nil
+}
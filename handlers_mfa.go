@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+type secondFactorParameters struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (c *apiConfig) handlerLoginMFA(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactorParameters
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	userID, err := c.keys.ValidatePurposeJWT(params.MFAToken, auth.PurposeMFA)
+	if err != nil {
+		if errors.Is(err, auth.ErrTokenExpired) {
+			respondWithErrorCode(w, 401, "token_expired", "MFA token has expired, log in again")
+			return
+		}
+		respondWithErrorCode(w, 401, "token_invalid", "Invalid MFA token")
+		return
+	}
+	user, err := c.db.GetUserByID(req.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 401, "Invalid or expired MFA token")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	attempt, ok := c.reserveLoginAttempt(w, req, user.Email)
+	if !ok {
+		return
+	}
+	ok, err = c.verifySecondFactor(req.Context(), user, params.secondFactorParameters)
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	if !ok {
+		respondWithErrorCode(w, 401, "invalid_credentials", "Invalid code")
+		return
+	}
+	c.releaseLoginAttempt(req, attempt)
+	c.recordLoginSuccess(req, user.Email)
+	c.respondWithNewSession(w, req, user)
+}
+
+func (c *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	user := principalFrom(req.Context()).User
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two factor authentication is already enabled")
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, 500, "Failed to create secret")
+		return
+	}
+	err = c.db.SetTOTPSecret(req.Context(), database.SetTOTPSecretParams{ID: user.ID, TotpSecret: sql.NullString{String: secret, Valid: true}})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (c *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	user := principalFrom(req.Context()).User
+	var params secondFactorParameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two factor authentication is already enabled")
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, 400, "Two factor authentication enrollment was not started")
+		return
+	}
+	step, ok, err := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now(), user.TotpLastStep)
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create recovery codes")
+		return
+	}
+	// Two factor authentication is only on once the recovery codes handed
+	// out with it are stored.
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		err := c.replaceRecoveryCodes(req.Context(), q, user, codes)
+		if err != nil {
+			return err
+		}
+		return q.EnableTOTP(req.Context(), database.EnableTOTPParams{ID: user.ID, TotpLastStep: step})
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+func (c *apiConfig) handlerDisableTOTP(w http.ResponseWriter, req *http.Request) {
+	user := principalFrom(req.Context()).User
+	var params secondFactorParameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two factor authentication is not enabled")
+		return
+	}
+	// A stolen session must not be able to guess its way past the second
+	// factor, so codes count against the same throttle as logins.
+	attempt, ok := c.reserveLoginAttempt(w, req, user.Email)
+	if !ok {
+		return
+	}
+	ok, err = c.verifySecondFactor(req.Context(), user, params)
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+	c.releaseLoginAttempt(req, attempt)
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		err := q.DeleteRecoveryCodes(req.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		return q.DisableTOTP(req.Context(), user.ID)
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Both are consumed on success so they can not be used a second time.
+func (c *apiConfig) verifySecondFactor(ctx context.Context, user database.User, params secondFactorParameters) (bool, error) {
+	if !user.TotpEnabledAt.Valid || !user.TotpSecret.Valid {
+		return false, nil
+	}
+	if params.Code != "" {
+		step, ok, err := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now(), user.TotpLastStep)
+		if err != nil || !ok {
+			return false, err
+		}
+		// Losing this race means a concurrent request already used the code.
+		used, err := c.db.UseTOTPStep(ctx, database.UseTOTPStepParams{ID: user.ID, TotpLastStep: step})
+		if err != nil {
+			return false, err
+		}
+		return used == 1, nil
+	}
+	if params.RecoveryCode != "" {
+		used, err := c.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: c.hashToken(auth.NormalizeRecoveryCode(params.RecoveryCode)),
+		})
+		if err != nil {
+			return false, err
+		}
+		return used == 1, nil
+	}
+	return false, nil
+}
+
+func (c *apiConfig) replaceRecoveryCodes(ctx context.Context, q *database.Queries, user database.User, codes []string) error {
+	err := q.DeleteRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		err = q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{UserID: user.ID, CodeHash: c.hashToken(code)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
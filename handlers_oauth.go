@@ -0,0 +1,509 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/oidc"
+	"github.com/google/uuid"
+)
+
+// oauthCodeLifetime is how long a client has to redeem an authorization code.
+const oauthCodeLifetime = 5 * time.Minute
+
+type authorizeParameters struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	client      database.OauthClient
+	redirectURI string
+	scopes      []string
+	state       string
+	challenge   string
+}
+
+// redirect builds the URI the user is sent back to the client with.
+func (a *authorizeRequest) redirect(values url.Values) string {
+	if a.state != "" {
+		values.Set("state", a.state)
+	}
+	separator := "?"
+	if strings.Contains(a.redirectURI, "?") {
+		separator = "&"
+	}
+	return a.redirectURI + separator + values.Encode()
+}
+
+// authorizeError is an error that is reported to the client through the
+// redirect URI, because the client and the URI were already verified.
+type authorizeError struct {
+	code        string
+	description string
+}
+
+func (e *authorizeError) Error() string {
+	return e.code + ": " + e.description
+}
+
+// handlerAuthorizePage is the RFC 6749 authorization endpoint clients send
+// the browser to. It serves the consent screen, which logs the user in and
+// talks to handlerAuthorize and handlerAuthorizeDecision with the query
+// string it was opened with.
+func (c *apiConfig) handlerAuthorizePage(w http.ResponseWriter, req *http.Request) {
+	// Nobody may frame the page and trick the user into clicking Allow.
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFile(w, req, "assets/oauth_consent.html")
+}
+
+// handlerAuthorize returns what the consent screen shows the user: the
+// client, the scopes it asks for and where the user goes afterwards. It takes
+// the authorization request parameters in the query string.
+func (c *apiConfig) handlerAuthorize(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	authReq, ok := c.validateAuthorizeRequest(w, req, authorizeParameters{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	})
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, map[string]any{
+		"client_id":    authReq.client.ID,
+		"client_name":  authReq.client.Name,
+		"redirect_uri": authReq.redirectURI,
+		"scopes":       authReq.scopes,
+	})
+}
+
+// handlerAuthorizeDecision records the answer of the consent screen and
+// responds with the URI to send the user back to the client with.
+func (c *apiConfig) handlerAuthorizeDecision(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		authorizeParameters
+		Approve bool `json:"approve"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	authReq, ok := c.validateAuthorizeRequest(w, req, params.authorizeParameters)
+	if !ok {
+		return
+	}
+	if !params.Approve {
+		respondWithJSON(w, 200, map[string]string{
+			"redirect_to": authReq.redirect(url.Values{"error": {"access_denied"}}),
+		})
+		return
+	}
+
+	code, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, 500, "Failed to create authorization code")
+		return
+	}
+	err = c.db.CreateOAuthAuthorizationCode(req.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      c.hashToken(code),
+		ClientID:      authReq.client.ID,
+		UserID:        principalFrom(req.Context()).User.ID,
+		RedirectUri:   authReq.redirectURI,
+		Scopes:        authReq.scopes,
+		CodeChallenge: authReq.challenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeLifetime),
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, map[string]string{
+		"redirect_to": authReq.redirect(url.Values{"code": {code}}),
+	})
+}
+
+// validateAuthorizeRequest checks an authorization request. Problems with the
+// client or the redirect URI are answered with a plain 400, since the user
+// must not be sent to a URI that was not registered. Everything else is
+// handed back to the client through the redirect.
+func (c *apiConfig) validateAuthorizeRequest(w http.ResponseWriter, req *http.Request, params authorizeParameters) (*authorizeRequest, bool) {
+	client, err := c.db.GetOAuthClient(req.Context(), params.ClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, 400, "invalid_client", "Unknown client")
+			return nil, false
+		}
+		respondWithError(w, 500, "Database error")
+		return nil, false
+	}
+	redirectURI := params.RedirectURI
+	if redirectURI == "" && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	// Clients registered before the scheme rules tightened may hold URIs
+	// that are no longer accepted.
+	if !slices.Contains(client.RedirectUris, redirectURI) || validateRedirectURI(redirectURI) != nil {
+		respondWithErrorCode(w, 400, "invalid_redirect_uri", "Redirect URI is not registered for this client")
+		return nil, false
+	}
+	authReq := &authorizeRequest{
+		client:      client,
+		redirectURI: redirectURI,
+		state:       params.State,
+		challenge:   params.CodeChallenge,
+	}
+
+	authErr := func() *authorizeError {
+		if params.ResponseType != "code" {
+			return &authorizeError{"unsupported_response_type", "Only the code response type is supported"}
+		}
+		if params.CodeChallenge == "" || params.CodeChallengeMethod != "S256" {
+			return &authorizeError{"invalid_request", "PKCE with the S256 method is required"}
+		}
+		if params.Scope == "" {
+			authReq.scopes = client.Scopes
+			return nil
+		}
+		scopes, err := auth.ParseScope(params.Scope)
+		if err != nil {
+			return &authorizeError{"invalid_scope", err.Error()}
+		}
+		for _, scope := range scopes {
+			if !slices.Contains(client.Scopes, scope) {
+				return &authorizeError{"invalid_scope", "The client may not request " + scope}
+			}
+		}
+		authReq.scopes = scopes
+		return nil
+	}()
+	if authErr != nil {
+		respondWithJSON(w, 400, map[string]string{
+			"error":       authErr.description,
+			"code":        authErr.code,
+			"redirect_to": authReq.redirect(url.Values{"error": {authErr.code}, "error_description": {authErr.description}}),
+		})
+		return nil, false
+	}
+	return authReq, true
+}
+
+// handlerOAuthToken is the RFC 6749 token endpoint for the authorization_code
+// and refresh_token grants.
+func (c *apiConfig) handlerOAuthToken(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form body")
+		return
+	}
+	client, ok := c.authenticateOAuthClient(w, req)
+	if !ok {
+		return
+	}
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		c.grantAuthorizationCode(w, req, client)
+	case "refresh_token":
+		c.grantRefreshToken(w, req, client)
+	default:
+		respondWithOAuthError(w, 400, "unsupported_grant_type", "Supported grants are authorization_code and refresh_token")
+	}
+}
+
+func (c *apiConfig) grantAuthorizationCode(w http.ResponseWriter, req *http.Request, client database.OauthClient) {
+	code, err := c.db.ConsumeOAuthAuthorizationCode(req.Context(), c.hashToken(req.PostForm.Get("code")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, 400, "invalid_grant", "Invalid or expired authorization code")
+			return
+		}
+		respondWithOAuthError(w, 500, "server_error", "Database error")
+		return
+	}
+	if code.ClientID != client.ID || code.RedirectUri != req.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, 400, "invalid_grant", "Authorization code was issued for another client or redirect URI")
+		return
+	}
+	challenge := oidc.CodeChallenge(req.PostForm.Get("code_verifier"))
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+		respondWithOAuthError(w, 400, "invalid_grant", "Invalid code verifier")
+		return
+	}
+	sessionID := uuid.New()
+	refreshToken, err := c.createRefreshToken(req, code.UserID, sessionID, time.Now().UTC(), sql.NullString{String: client.ID, Valid: true}, code.Scopes)
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "Failed to create refresh token")
+		return
+	}
+	c.respondWithOAuthTokens(w, code.UserID, sessionID, client.ID, code.Scopes, refreshToken)
+}
+
+func (c *apiConfig) grantRefreshToken(w http.ResponseWriter, req *http.Request, client database.OauthClient) {
+	storedToken, err := c.db.GetRefreshToken(req.Context(), c.hashToken(req.PostForm.Get("refresh_token")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, 400, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		respondWithOAuthError(w, 500, "server_error", "Database error")
+		return
+	}
+	if storedToken.ClientID.String != client.ID {
+		respondWithOAuthError(w, 400, "invalid_grant", "Invalid refresh token")
+		return
+	}
+	if !storedToken.ReplacedBy.Valid && (storedToken.RevokedAt.Valid || storedToken.ExpiresAt.Before(time.Now().UTC())) {
+		respondWithOAuthError(w, 400, "invalid_grant", "Invalid refresh token")
+		return
+	}
+	scopes, err := narrowScopes(storedToken.Scopes, req.PostForm.Get("scope"))
+	if err != nil {
+		respondWithOAuthError(w, 400, "invalid_scope", err.Error())
+		return
+	}
+
+	newRefreshToken, err := c.rotateRefreshToken(req, storedToken)
+	if err != nil {
+		if errors.Is(err, errRefreshTokenReused) {
+			respondWithOAuthError(w, 400, "invalid_grant", "Refresh token reuse detected")
+			return
+		}
+		respondWithOAuthError(w, 500, "server_error", "Failed to rotate refresh token")
+		return
+	}
+	c.respondWithOAuthTokens(w, storedToken.UserID, storedToken.FamilyID, client.ID, scopes, newRefreshToken)
+}
+
+// narrowScopes returns the scopes a refresh asks for. A client may ask for
+// fewer scopes than it was granted, never more, and keeps all of them when it
+// does not ask.
+func narrowScopes(granted []string, scope string) ([]string, error) {
+	if scope == "" {
+		return granted, nil
+	}
+	requested, err := auth.ParseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range requested {
+		if !slices.Contains(granted, s) {
+			return nil, errors.New("The refresh token was not granted " + s)
+		}
+	}
+	return requested, nil
+}
+
+func (c *apiConfig) respondWithOAuthTokens(w http.ResponseWriter, userID, sessionID uuid.UUID, clientID string, scopes []string, refreshToken string) {
+	claims := auth.NewClaims(userID, accessTokenLifetime)
+	claims.SessionID = sessionID.String()
+	claims.ClientID = clientID
+	claims.Scope = strings.Join(scopes, " ")
+	accessToken, err := c.keys.Sign(claims)
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "Failed to create token")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, 200, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenLifetime.Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         claims.Scope,
+	})
+}
+
+// handlerOAuthIntrospect is the RFC 7662 introspection endpoint. Clients can
+// only introspect their own tokens, every other token is reported inactive.
+// A token is active exactly when the API would accept it.
+func (c *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form body")
+		return
+	}
+	client, ok := c.authenticateOAuthClient(w, req)
+	if !ok {
+		return
+	}
+	if !client.SecretHash.Valid {
+		respondWithOAuthError(w, 401, "invalid_client", "Only confidential clients can introspect tokens")
+		return
+	}
+	inactive := map[string]bool{"active": false}
+	token := req.PostForm.Get("token")
+
+	if claims, err := c.keys.ValidateClaims(token); err == nil {
+		if claims.ClientID != client.ID {
+			respondWithJSON(w, 200, inactive)
+			return
+		}
+		userID, err := claims.UserID()
+		if err != nil {
+			respondWithJSON(w, 200, inactive)
+			return
+		}
+		sessionID, _ := uuid.Parse(claims.SessionID)
+		if !c.introspectUser(w, req, userID, sessionID) {
+			return
+		}
+		respondWithJSON(w, 200, map[string]any{
+			"active":     true,
+			"token_type": "access_token",
+			"client_id":  claims.ClientID,
+			"scope":      claims.Scope,
+			"sub":        claims.Subject,
+			"exp":        claims.ExpiresAt.Unix(),
+			"iat":        claims.IssuedAt.Unix(),
+		})
+		return
+	}
+
+	storedToken, err := c.db.GetRefreshToken(req.Context(), c.hashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithJSON(w, 200, inactive)
+			return
+		}
+		respondWithOAuthError(w, 500, "server_error", "Database error")
+		return
+	}
+	if storedToken.ClientID.String != client.ID || storedToken.RevokedAt.Valid || storedToken.ExpiresAt.Before(time.Now().UTC()) {
+		respondWithJSON(w, 200, inactive)
+		return
+	}
+	if !c.introspectUser(w, req, storedToken.UserID, storedToken.FamilyID) {
+		return
+	}
+	respondWithJSON(w, 200, map[string]any{
+		"active":     true,
+		"token_type": "refresh_token",
+		"client_id":  client.ID,
+		"scope":      strings.Join(storedToken.Scopes, " "),
+		"sub":        storedToken.UserID.String(),
+		"exp":        storedToken.ExpiresAt.Unix(),
+		"iat":        storedToken.CreatedAt.Unix(),
+	})
+}
+
+// introspectUser runs the checks of tokenUser for an introspected token. It
+// responds and reports false when the token is inactive or the check failed.
+func (c *apiConfig) introspectUser(w http.ResponseWriter, req *http.Request, userID, sessionID uuid.UUID) bool {
+	_, err := c.tokenUser(req.Context(), userID, sessionID)
+	if errors.Is(err, errDatabase) {
+		respondWithOAuthError(w, 500, "server_error", "Database error")
+		return false
+	}
+	if err != nil {
+		respondWithJSON(w, 200, map[string]bool{"active": false})
+		return false
+	}
+	return true
+}
+
+// handlerOAuthRevoke is the RFC 7009 revocation endpoint. Revoking either
+// kind of token ends the whole grant, its refresh tokens as well as the
+// access tokens already handed out. Unknown tokens are not an error.
+func (c *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form body")
+		return
+	}
+	client, ok := c.authenticateOAuthClient(w, req)
+	if !ok {
+		return
+	}
+	token := req.PostForm.Get("token")
+
+	var familyID uuid.UUID
+	if claims, err := c.keys.ValidateClaims(token); err == nil {
+		if claims.ClientID == client.ID {
+			familyID, _ = uuid.Parse(claims.SessionID)
+		}
+	} else {
+		storedToken, err := c.db.GetRefreshToken(req.Context(), c.hashToken(token))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, 500, "server_error", "Database error")
+			return
+		}
+		if err == nil && storedToken.ClientID.String == client.ID {
+			familyID = storedToken.FamilyID
+		}
+	}
+	if familyID != uuid.Nil {
+		if err := c.db.RevokeTokenFamily(req.Context(), familyID); err != nil {
+			respondWithOAuthError(w, 500, "server_error", "Failed to revoke token")
+			return
+		}
+	}
+	w.WriteHeader(200)
+}
+
+// authenticateOAuthClient identifies the client of a token endpoint request
+// from HTTP basic auth or the client_id and client_secret form fields. Public
+// clients only send their id.
+func (c *apiConfig) authenticateOAuthClient(w http.ResponseWriter, req *http.Request) (database.OauthClient, bool) {
+	clientID, secret, basic := req.BasicAuth()
+	if basic {
+		// RFC 6749 form encodes the credentials before base64 encoding them.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+	client, err := c.db.GetOAuthClient(req.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthClientError(w, basic)
+			return database.OauthClient{}, false
+		}
+		respondWithOAuthError(w, 500, "server_error", "Database error")
+		return database.OauthClient{}, false
+	}
+	if client.SecretHash.Valid && subtle.ConstantTimeCompare([]byte(c.hashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		respondWithOAuthClientError(w, basic)
+		return database.OauthClient{}, false
+	}
+	return client, true
+}
+
+func respondWithOAuthClientError(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithOAuthError(w, 401, "invalid_client", "Client authentication failed")
+}
+
+// respondWithOAuthError writes the error shape RFC 6749 requires from the
+// token endpoint.
+func respondWithOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
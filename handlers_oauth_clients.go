@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/oidc"
+)
+
+// OAuthClient is a third party app registered by a user. The secret is only
+// part of the response that registered it.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func oauthClientResponse(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func (c *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		// Confidential clients run on a server and get a secret. Public
+		// clients rely on PKCE alone.
+		Confidential bool `json:"confidential"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxTokenNameLength {
+		respondWithError(w, 400, fmt.Sprintf("Name must be between 1 and %d characters", maxTokenNameLength))
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, 400, "At least one redirect URI is required")
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+	scopes, err := auth.NormalizeScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	clientID, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, 500, "Failed to create client")
+		return
+	}
+	var secret string
+	var secretHash sql.NullString
+	if params.Confidential {
+		secret, err = oidc.NewRandomString()
+		if err != nil {
+			respondWithError(w, 500, "Failed to create client")
+			return
+		}
+		secretHash = sql.NullString{String: c.hashToken(secret), Valid: true}
+	}
+	client, err := c.db.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           clientID,
+		OwnerID:      principalFrom(req.Context()).User.ID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       scopes,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	response := oauthClientResponse(client)
+	response.Secret = secret
+	respondWithJSON(w, 201, response)
+}
+
+func (c *apiConfig) handlerListOAuthClients(w http.ResponseWriter, req *http.Request) {
+	rows, err := c.db.ListOAuthClients(req.Context(), principalFrom(req.Context()).User.ID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	clients := make([]OAuthClient, 0, len(rows))
+	for _, row := range rows {
+		clients = append(clients, oauthClientResponse(row))
+	}
+	respondWithJSON(w, 200, clients)
+}
+
+// handlerDeleteOAuthClient removes a client together with every code and
+// refresh token issued to it.
+func (c *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, req *http.Request) {
+	deleted, err := c.db.DeleteOAuthClient(req.Context(), database.DeleteOAuthClientParams{
+		ID:      req.PathValue("clientID"),
+		OwnerID: principalFrom(req.Context()).User.ID,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Client not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// validateRedirectURI accepts absolute URIs without a fragment that use
+// https, http on a loopback address, or a reverse domain name private-use
+// scheme like com.example.app, the choices RFC 8252 gives native apps. Other
+// schemes such as javascript: or data: would run in the user's browser.
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return fmt.Errorf("Invalid redirect URI %q", redirectURI)
+	}
+	switch {
+	case u.Scheme == "https":
+		if u.Host == "" {
+			return fmt.Errorf("Invalid redirect URI %q", redirectURI)
+		}
+	case u.Scheme == "http":
+		host := u.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("Redirect URI %q has to use https", redirectURI)
+		}
+	case !strings.Contains(u.Scheme, "."):
+		return fmt.Errorf("Redirect URI %q has to use https or a reverse domain name scheme", redirectURI)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+)
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{"https://client.example.com/callback", false},
+		{"https://client.example.com/callback?app=1", false},
+		{"http://localhost:3000/callback", false},
+		{"http://127.0.0.1/callback", false},
+		{"http://[::1]:8080/callback", false},
+		{"com.example.app:/callback", false},
+		{"http://client.example.com/callback", true},
+		{"/callback", true},
+		{"https://client.example.com/callback#token", true},
+		{"https://client example.com", true},
+		{"https:///callback", true},
+		{"javascript:alert(1)", true},
+		{"JavaScript:alert(1)", true},
+		{"data:text/html,<script>alert(1)</script>", true},
+		{"file:///etc/passwd", true},
+		{"vbscript:msgbox(1)", true},
+		{"myapp:/callback", true},
+	}
+	for _, tt := range tests {
+		err := validateRedirectURI(tt.uri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateRedirectURI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAuthorizeRequest_Redirect(t *testing.T) {
+	tests := []struct {
+		name        string
+		redirectURI string
+		state       string
+		want        string
+	}{
+		{
+			name:        "With state",
+			redirectURI: "https://client.example.com/callback",
+			state:       "xyz",
+			want:        "https://client.example.com/callback?code=abc&state=xyz",
+		},
+		{
+			name:        "Without state",
+			redirectURI: "https://client.example.com/callback",
+			want:        "https://client.example.com/callback?code=abc",
+		},
+		{
+			name:        "Existing query",
+			redirectURI: "https://client.example.com/callback?app=1",
+			state:       "a b&c",
+			want:        "https://client.example.com/callback?app=1&code=abc&state=a+b%26c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authReq := &authorizeRequest{redirectURI: tt.redirectURI, state: tt.state}
+			got := authReq.redirect(url.Values{"code": {"abc"}})
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNarrowScopes(t *testing.T) {
+	granted := []string{auth.ScopeChirpsRead, auth.ScopeChirpsWrite}
+
+	scopes, err := narrowScopes(granted, "")
+	if err != nil {
+		t.Fatalf("narrowScopes failed: %v", err)
+	}
+	if !slices.Equal(scopes, granted) {
+		t.Errorf("Expected all granted scopes without a scope, got %v", scopes)
+	}
+
+	scopes, err = narrowScopes(granted, auth.ScopeChirpsRead)
+	if err != nil {
+		t.Fatalf("narrowScopes failed: %v", err)
+	}
+	if !slices.Equal(scopes, []string{auth.ScopeChirpsRead}) {
+		t.Errorf("Expected the narrower scope, got %v", scopes)
+	}
+
+	if _, err := narrowScopes(granted, auth.ScopeChirpsRead+" "+auth.ScopeProfileWrite); err == nil {
+		t.Error("Expected error for a scope that was not granted")
+	}
+	if _, err := narrowScopes(granted, "chirps:delete"); err == nil {
+		t.Error("Expected error for an unknown scope")
+	}
+}
+
+func TestHandlerAuthorizePage(t *testing.T) {
+	c := &apiConfig{}
+	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=app", nil)
+	w := httptest.NewRecorder()
+	c.handlerAuthorizePage(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected an HTML page, got %q", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'") {
+		t.Error("Expected the consent screen to refuse being framed")
+	}
+	if !strings.Contains(w.Body.String(), "oauth_consent.js") {
+		t.Error("Expected the page to load the consent script")
+	}
+}
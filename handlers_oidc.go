@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/oidc"
+)
+
+// oidcLoginLifetime is how long the user has to finish logging in at the
+// provider.
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcStateCookie holds the state of a login in the browser that started it.
+// The callback only accepts the state back from that browser, so nobody can
+// finish a login of their own in someone else's browser.
+const oidcStateCookie = "oidc_state"
+
+var (
+	errUnverifiedProviderEmail = errors.New("provider did not verify the email")
+	errUnverifiedLocalEmail    = errors.New("local account email is not verified")
+)
+
+// handlerOIDCLogin sends the user to the provider to log in.
+func (c *apiConfig) handlerOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	provider, ok := c.oidcProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(w, 404, "Unknown login provider")
+		return
+	}
+	state, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	nonce, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	verifier, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	authURL, err := provider.AuthCodeURL(req.Context(), state, nonce, verifier)
+	if err != nil {
+		fmt.Printf("Failed to start %s login: %v\n", provider.Name(), err)
+		respondWithError(w, 502, "Login provider is unavailable")
+		return
+	}
+	if err := c.db.DeleteExpiredOIDCLoginStates(req.Context()); err != nil {
+		fmt.Printf("Failed to delete expired login states: %v\n", err)
+	}
+	err = c.db.CreateOIDCLoginState(req.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:    c.hashToken(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginLifetime),
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	c.setOIDCStateCookie(w, req, state, oidcLoginLifetime)
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// setOIDCStateCookie sets the state cookie for the callback of the current
+// provider, or clears it when maxAge is zero. Lax keeps it on the top level
+// redirect back from the provider.
+func (c *apiConfig) setOIDCStateCookie(w http.ResponseWriter, req *http.Request, state string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/" + req.PathValue("provider") + "/callback",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   strings.HasPrefix(c.baseURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge == 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// handlerOIDCCallback finishes a login the provider sent the user back from.
+// It responds like handlerLogin.
+func (c *apiConfig) handlerOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	provider, ok := c.oidcProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(w, 404, "Unknown login provider")
+		return
+	}
+	query := req.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithErrorCode(w, 401, "provider_error", "Login was not completed: "+providerErr)
+		return
+	}
+	cookie, err := req.Cookie(oidcStateCookie)
+	c.setOIDCStateCookie(w, req, "", 0)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondWithErrorCode(w, 400, "invalid_state", "Login was started in another browser, start again")
+		return
+	}
+	loginState, err := c.db.ConsumeOIDCLoginState(req.Context(), database.ConsumeOIDCLoginStateParams{
+		StateHash: c.hashToken(query.Get("state")),
+		Provider:  provider.Name(),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, 400, "invalid_state", "Login expired or was already used, start again")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	claims, err := provider.Exchange(req.Context(), query.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		fmt.Printf("Failed to finish %s login: %v\n", provider.Name(), err)
+		respondWithErrorCode(w, 401, "provider_error", "Could not verify the login with the provider")
+		return
+	}
+	user, err := c.userForIdentity(req.Context(), provider.Name(), claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedLocalEmail):
+			respondWithError(w, 409, "An account with this email exists, log in with your password and verify the email first")
+		case errors.Is(err, errUnverifiedProviderEmail):
+			respondWithError(w, 403, "The provider did not verify your email address")
+		default:
+			respondWithError(w, 500, "Database error")
+		}
+		return
+	}
+	c.completeLogin(w, req, user)
+}
+
+// userForIdentity returns the user an external identity belongs to. Unknown
+// identities are linked to the account with the same verified email, or get
+// a new account without a password.
+func (c *apiConfig) userForIdentity(ctx context.Context, provider string, claims *oidc.IDTokenClaims) (database.User, error) {
+	identity, err := c.db.GetUserIdentity(ctx, database.GetUserIdentityParams{Provider: provider, Subject: claims.Subject})
+	if err == nil {
+		return c.db.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	// Linking by email is only safe when the provider vouches for it.
+	if !claims.EmailVerified || validateEmail(claims.Email) != nil {
+		return database.User{}, errUnverifiedProviderEmail
+	}
+
+	var user database.User
+	err = c.withTx(ctx, func(q *database.Queries) error {
+		user, err = q.GetUserByEmail(ctx, claims.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			// 'unset' is no valid argon2id hash, so the account can only log
+			// in through the provider until a password is set.
+			user, err = q.CreateUser(ctx, database.CreateUserParams{Email: claims.Email, HashedPassword: "unset"})
+			if err != nil {
+				return err
+			}
+			user, err = q.ConfirmUserEmail(ctx, database.ConfirmUserEmailParams{ID: user.ID, Email: claims.Email})
+		} else if err == nil && !user.EmailVerifiedAt.Valid {
+			// Whoever signed up with the address never proved they own it.
+			return errUnverifiedLocalEmail
+		}
+		if err != nil {
+			return err
+		}
+		return q.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+			Provider: provider,
+			Subject:  claims.Subject,
+			UserID:   user.ID,
+			Email:    claims.Email,
+		})
+	})
+	return user, err
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// Passkey is a WebAuthn credential a user can log in with instead of a
+// password. Its ID is the base64url encoded credential ID.
+type Passkey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func passkeyResponse(credential database.WebauthnCredential) Passkey {
+	response := Passkey{
+		ID:        base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name:      credential.Name,
+		CreatedAt: credential.CreatedAt,
+	}
+	if credential.LastUsedAt.Valid {
+		response.LastUsedAt = &credential.LastUsedAt.Time
+	}
+	return response
+}
+
+// handlerBeginPasskeyRegistration responds with the options for
+// navigator.credentials.create().
+func (c *apiConfig) handlerBeginPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	user := principalFrom(req.Context()).User
+	credentials, err := c.db.ListWebAuthnCredentials(req.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	exclude := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		exclude = append(exclude, credential.ID)
+	}
+	challenge, ok := c.createWebAuthnChallenge(w, req, uuid.NullUUID{UUID: user.ID, Valid: true}, ceremonyRegister)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, map[string]any{
+		"publicKey": c.webauthn.CreationOptions(webauthn.User{
+			ID:          user.ID[:],
+			Name:        user.Email,
+			DisplayName: user.Email,
+		}, challenge, exclude),
+	})
+}
+
+func (c *apiConfig) handlerFinishPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Name     string                       `json:"name"`
+		Response webauthn.AttestationResponse `json:"response"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		params.Name = "Passkey"
+	}
+	if len(params.Name) > maxTokenNameLength {
+		respondWithError(w, 400, fmt.Sprintf("Name must be between 1 and %d characters", maxTokenNameLength))
+		return
+	}
+
+	user := principalFrom(req.Context()).User
+	challenge, ok := c.consumeWebAuthnChallenge(w, req, params.Response.ClientDataJSON, ceremonyRegister)
+	if !ok {
+		return
+	}
+	if challenge.userID.UUID != user.ID {
+		respondWithErrorCode(w, 400, "invalid_challenge", "Registration expired or was already used, start again")
+		return
+	}
+	credential, err := c.webauthn.VerifyRegistration(challenge.challenge, params.Response)
+	if err != nil {
+		fmt.Printf("Passkey registration failed: %v\n", err)
+		respondWithErrorCode(w, 400, "invalid_credential", "Could not verify the passkey")
+		return
+	}
+	stored, err := c.db.CreateWebAuthnCredential(req.Context(), database.CreateWebAuthnCredentialParams{
+		ID:        credential.ID,
+		UserID:    user.ID,
+		Name:      params.Name,
+		PublicKey: credential.PublicKey,
+		SignCount: int64(credential.SignCount),
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, 409, "This passkey is already registered")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 201, passkeyResponse(stored))
+}
+
+func (c *apiConfig) handlerListPasskeys(w http.ResponseWriter, req *http.Request) {
+	rows, err := c.db.ListWebAuthnCredentials(req.Context(), principalFrom(req.Context()).User.ID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	passkeys := make([]Passkey, 0, len(rows))
+	for _, row := range rows {
+		passkeys = append(passkeys, passkeyResponse(row))
+	}
+	respondWithJSON(w, 200, passkeys)
+}
+
+func (c *apiConfig) handlerDeletePasskey(w http.ResponseWriter, req *http.Request) {
+	credentialID, err := base64.RawURLEncoding.DecodeString(req.PathValue("passkeyID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid passkey ID")
+		return
+	}
+	deleted, err := c.db.DeleteWebAuthnCredential(req.Context(), database.DeleteWebAuthnCredentialParams{
+		ID:     credentialID,
+		UserID: principalFrom(req.Context()).User.ID,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Passkey not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handlerBeginPasskeyLogin responds with the options for
+// navigator.credentials.get(). The user is not known yet, the authenticator
+// offers the passkeys it has for this site.
+func (c *apiConfig) handlerBeginPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	challenge, ok := c.createWebAuthnChallenge(w, req, uuid.NullUUID{}, ceremonyLogin)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, map[string]any{
+		"publicKey": c.webauthn.RequestOptions(challenge, nil),
+	})
+}
+
+// handlerFinishPasskeyLogin responds like handlerLogin. A passkey proves both
+// possession and user verification, so no second factor is asked for.
+func (c *apiConfig) handlerFinishPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		ID       webauthn.URLEncodedBase64  `json:"id"`
+		Response webauthn.AssertionResponse `json:"response"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	challenge, ok := c.consumeWebAuthnChallenge(w, req, params.Response.ClientDataJSON, ceremonyLogin)
+	if !ok {
+		return
+	}
+	credential, err := c.db.GetWebAuthnCredential(req.Context(), params.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, 401, "invalid_credentials", "Unknown passkey")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	// The user handle is the user's ID, a passkey of one account must not
+	// log in to another.
+	if len(params.Response.UserHandle) != 0 && !bytes.Equal(params.Response.UserHandle, credential.UserID[:]) {
+		respondWithErrorCode(w, 401, "invalid_credentials", "Unknown passkey")
+		return
+	}
+	signCount, err := c.webauthn.VerifyAssertion(challenge.challenge, webauthn.Credential{
+		ID:        credential.ID,
+		PublicKey: credential.PublicKey,
+		SignCount: uint32(credential.SignCount),
+	}, params.Response)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCountRegressed) {
+			fmt.Printf("Passkey %x of user %s reported an old signature counter, it may be cloned\n", credential.ID, credential.UserID)
+			respondWithErrorCode(w, 401, "cloned_credential", "This passkey may have been copied, use another way to log in")
+			return
+		}
+		fmt.Printf("Passkey login failed: %v\n", err)
+		respondWithErrorCode(w, 401, "invalid_credentials", "Could not verify the passkey")
+		return
+	}
+	// Two logins racing with the same counter cannot both win.
+	updated, err := c.db.UpdateWebAuthnSignCount(req.Context(), database.UpdateWebAuthnSignCountParams{
+		ID:           credential.ID,
+		NewSignCount: int64(signCount),
+		OldSignCount: credential.SignCount,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	if updated == 0 {
+		respondWithErrorCode(w, 401, "invalid_credentials", "Could not verify the passkey")
+		return
+	}
+	user, err := c.db.GetUserByID(req.Context(), credential.UserID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	c.recordLoginSuccess(req, user.Email)
+	c.respondWithNewSession(w, req, user)
+}
+
+// webAuthnChallenge is a stored challenge of a ceremony in progress.
+type webAuthnChallenge struct {
+	challenge []byte
+	userID    uuid.NullUUID
+}
+
+// createWebAuthnChallenge starts a ceremony. Only the hash of the challenge is
+// stored, the response carries the challenge back in its client data.
+func (c *apiConfig) createWebAuthnChallenge(w http.ResponseWriter, req *http.Request, userID uuid.NullUUID, ceremony string) ([]byte, bool) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return nil, false
+	}
+	if err := c.db.DeleteExpiredWebAuthnChallenges(req.Context()); err != nil {
+		fmt.Printf("Failed to delete expired passkey challenges: %v\n", err)
+	}
+	err = c.db.CreateWebAuthnChallenge(req.Context(), database.CreateWebAuthnChallengeParams{
+		ChallengeHash: c.hashToken(base64.RawURLEncoding.EncodeToString(challenge)),
+		UserID:        userID,
+		Ceremony:      ceremony,
+		ExpiresAt:     time.Now().UTC().Add(webauthn.ChallengeTimeout),
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return nil, false
+	}
+	return challenge, true
+}
+
+// consumeWebAuthnChallenge finds the ceremony a response belongs to and
+// makes sure it cannot be finished twice.
+func (c *apiConfig) consumeWebAuthnChallenge(w http.ResponseWriter, req *http.Request, clientDataJSON []byte, ceremony string) (*webAuthnChallenge, bool) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		respondWithError(w, 400, "Invalid client data")
+		return nil, false
+	}
+	stored, err := c.db.ConsumeWebAuthnChallenge(req.Context(), database.ConsumeWebAuthnChallengeParams{
+		ChallengeHash: c.hashToken(base64.RawURLEncoding.EncodeToString(clientData.Challenge)),
+		Ceremony:      ceremony,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, 400, "invalid_challenge", "Passkey request expired or was already used, start again")
+			return nil, false
+		}
+		respondWithError(w, 500, "Database error")
+		return nil, false
+	}
+	return &webAuthnChallenge{challenge: clientData.Challenge, userID: stored.UserID}, true
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/mail"
+)
+
+const passwordResetTokenLifetime = time.Hour
+
+func (c *apiConfig) handlerRequestPasswordReset(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	// Every request counts against the client address and the email, so the
+	// endpoint can not be used to flood someone's inbox, not even from many
+	// addresses. The email is counted whether or not it has an account.
+	ipAttempt, wait, err := c.ipThrottle.Reserve(req.Context(), "password-reset:ip:"+c.clientIP(req))
+	if err != nil {
+		respondWithError(w, 500, "Server Error")
+		return
+	}
+	if wait == 0 {
+		_, wait, err = c.accountThrottle.Reserve(req.Context(), passwordResetThrottleKey(params.Email))
+		if err != nil || wait > 0 {
+			// The request is not served, it must not count against the address.
+			if err := c.ipThrottle.Release(req.Context(), ipAttempt); err != nil {
+				fmt.Printf("Failed to release password reset request: %v\n", err)
+			}
+		}
+		if err != nil {
+			respondWithError(w, 500, "Server Error")
+			return
+		}
+	}
+	if wait > 0 {
+		respondWithTooManyAttempts(w, wait, "Too many password reset requests, try again later")
+		return
+	}
+
+	// The response is the same whether or not the email belongs to an
+	// account, and the mail goes out after it, so neither the response nor
+	// its timing can be used to enumerate users.
+	user, err := c.db.GetUserByEmail(req.Context(), params.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(202)
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	go c.sendPasswordReset(context.WithoutCancel(req.Context()), user)
+	w.WriteHeader(202)
+}
+
+// passwordResetThrottleKey is the throttle key of the reset requests for an
+// email. Like accountThrottleKey it ignores case and surrounding space.
+func passwordResetThrottleKey(email string) string {
+	return "password-reset:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// sendPasswordReset mails the user a new reset token. It runs after the
+// response is sent, failures are only logged.
+func (c *apiConfig) sendPasswordReset(ctx context.Context, user database.User) {
+	resetToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		fmt.Printf("Failed to create reset token: %v\n", err)
+		return
+	}
+	err = c.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: c.hashToken(resetToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTokenLifetime),
+	})
+	if err != nil {
+		fmt.Printf("Failed to store reset token: %v\n", err)
+		return
+	}
+
+	link := c.baseURL + "/app/reset-password?token=" + url.QueryEscape(resetToken)
+	err = c.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Open %s within the next hour to choose a new one.\n\nYour reset token is %s\n\n"+
+			"If this was not you, you can ignore this email.\n", link, resetToken),
+	})
+	if err != nil {
+		fmt.Printf("Failed to send password reset email: %v\n", err)
+	}
+}
+
+func (c *apiConfig) handlerConfirmPasswordReset(w http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	// The email is only known once the token is consumed, it is checked
+	// again then.
+	fields := fieldErrors{}
+	c.checkPassword(fields, params.Password)
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, fields)
+		return
+	}
+
+	errInvalidToken := errors.New("invalid reset token")
+	errPolicyViolation := errors.New("password violates the policy")
+	err = c.withTx(req.Context(), func(q *database.Queries) error {
+		userID, err := q.ConsumePasswordResetToken(req.Context(), c.hashToken(params.Token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errInvalidToken
+			}
+			return err
+		}
+		user, err := q.GetUserByID(req.Context(), userID)
+		if err != nil {
+			return err
+		}
+		c.checkPassword(fields, params.Password, user.Email)
+		if len(fields) > 0 {
+			// Rolling back keeps the token usable for another try.
+			return errPolicyViolation
+		}
+		// Hashing only once the token checked out keeps invalid tokens from
+		// costing us an argon2id run each.
+		hashedPwd, err := auth.HashPassword(params.Password)
+		if err != nil {
+			return err
+		}
+		err = q.UpdateUserPassword(req.Context(), database.UpdateUserPasswordParams{ID: userID, HashedPassword: hashedPwd})
+		if err != nil {
+			return err
+		}
+		err = q.InvalidatePasswordResetTokens(req.Context(), userID)
+		if err != nil {
+			return err
+		}
+		// Whoever knew the old password may still hold a session.
+		return q.RevokeAllUserTokens(req.Context(), userID)
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidToken) {
+			respondWithError(w, 400, "Invalid or expired reset token")
+			return
+		}
+		if errors.Is(err, errPolicyViolation) {
+			respondWithFieldErrors(w, fields)
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/throttle"
+)
+
+func TestHandlerRequestPasswordReset_ThrottlesEmail(t *testing.T) {
+	policy := throttle.Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+	store := throttle.NewMemoryStore()
+	c := &apiConfig{
+		accountThrottle: throttle.NewLimiter(store, policy),
+		ipThrottle:      throttle.NewLimiter(store, policy),
+	}
+	for i := 0; i < policy.FreeAttempts+1; i++ {
+		if _, wait, err := c.accountThrottle.Reserve(t.Context(), passwordResetThrottleKey("victim@example.com")); err != nil || wait > 0 {
+			t.Fatalf("Expected request %d to go ahead, got a wait of %v: %v", i+1, wait, err)
+		}
+	}
+
+	// Requests for the same email from other addresses are refused too,
+	// whatever the case and spacing of the email.
+	for i := 0; i < policy.FreeAttempts+1; i++ {
+		req := httptest.NewRequest("POST", "/api/password-reset/request", strings.NewReader(`{"email": " Victim@Example.com "}`))
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+		w := httptest.NewRecorder()
+		c.handlerRequestPasswordReset(w, req)
+		if w.Code != 429 {
+			t.Fatalf("Expected 429 from %s, got %d", req.RemoteAddr, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("Expected a Retry-After header")
+		}
+	}
+
+	// The refused requests did not count against the addresses.
+	for i := 0; i < policy.FreeAttempts+1; i++ {
+		if _, wait, _ := c.ipThrottle.Reserve(t.Context(), "password-reset:ip:192.0.2.1"); wait > 0 {
+			t.Fatalf("Expected the address not to be throttled, got a wait of %v", wait)
+		}
+	}
+}
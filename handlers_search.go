@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/search"
+	"github.com/google/uuid"
+)
+
+// ChirpSearchResult is a chirp that matched a search. Snippet is HTML: the
+// matched words are wrapped in <mark> tags and everything else is escaped.
+type ChirpSearchResult struct {
+	Chirp
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// handlerSearchChirps responds with one page of the chirps matching ?q=, the
+// best matches first unless sort=asc or sort=desc asks for creation order.
+// Search results are only paged forward, following the next link.
+func (c *apiConfig) handlerSearchChirps(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	tsquery, err := search.ParseQuery(query.Get("q"))
+	if err != nil {
+		if errors.Is(err, search.ErrQueryTooLong) {
+			respondWithError(w, 400, fmt.Sprintf("Query must be at most %d characters", search.MaxQueryLength))
+			return
+		}
+		respondWithError(w, 400, "Query must contain at least one word")
+		return
+	}
+	page, err := parseForwardPageRequest(query, "Search results")
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	querySort := query.Get("sort")
+	if querySort == "" {
+		querySort = "relevance"
+	}
+	if querySort != "relevance" && querySort != "asc" && querySort != "desc" {
+		respondWithError(w, 400, "Sort must be relevance, asc or desc")
+		return
+	}
+	authorID, err := parseAuthorFilter(query)
+	if err != nil {
+		respondWithError(w, 400, "Invalid author ID")
+		return
+	}
+
+	var rows []database.SearchChirpsByRankRow
+	maxRows := page.maxRows()
+	if querySort == "relevance" {
+		arg := database.SearchChirpsByRankParams{Query: tsquery, AuthorID: authorID, MaxRows: maxRows}
+		if page.after != nil {
+			arg.CursorID = uuid.NullUUID{UUID: page.after.ID, Valid: true}
+			arg.CursorRank = sql.NullFloat64{Float64: float64(page.after.Rank), Valid: true}
+		}
+		rows, err = c.db.SearchChirpsByRank(req.Context(), arg)
+	} else {
+		arg := database.SearchChirpsAscendingParams{Query: tsquery, AuthorID: authorID, MaxRows: maxRows}
+		if page.after != nil {
+			arg.CursorCreatedAt = sql.NullTime{Time: page.after.CreatedAt, Valid: true}
+			arg.CursorID = uuid.NullUUID{UUID: page.after.ID, Valid: true}
+		}
+		rows, err = c.searchChirpsByDate(req, arg, querySort == "desc")
+	}
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+
+	rows = setPage(c, w, req, page, rows, func(row database.SearchChirpsByRankRow) pageCursor {
+		cursor := pageCursor{CreatedAt: row.CreatedAt, ID: row.ID}
+		if querySort == "relevance" {
+			cursor.Rank = row.Rank
+		}
+		return cursor
+	})
+	results := make([]ChirpSearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, ChirpSearchResult{
+			Chirp: chirpResponse(database.Chirp{
+				ID:         row.ID,
+				CreatedAt:  row.CreatedAt,
+				UpdatedAt:  row.UpdatedAt,
+				Body:       row.Body,
+				UserID:     row.UserID,
+				InReplyTo:  row.InReplyTo,
+				ReplyCount: row.ReplyCount,
+				LikeCount:  row.LikeCount,
+			}),
+			Rank:    row.Rank,
+			Snippet: search.Snippet(row.Snippet),
+		})
+	}
+	likeable := make([]*Chirp, 0, len(results))
+	for i := range results {
+		likeable = append(likeable, &results[i].Chirp)
+	}
+	if err := c.setLikedByMe(req.Context(), likeable...); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, results)
+}
+
+func (c *apiConfig) searchChirpsByDate(req *http.Request, arg database.SearchChirpsAscendingParams, descending bool) ([]database.SearchChirpsByRankRow, error) {
+	var rows []database.SearchChirpsByRankRow
+	if descending {
+		found, err := c.db.SearchChirpsDescending(req.Context(), database.SearchChirpsDescendingParams(arg))
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range found {
+			rows = append(rows, database.SearchChirpsByRankRow(row))
+		}
+		return rows, nil
+	}
+	found, err := c.db.SearchChirpsAscending(req.Context(), arg)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range found {
+		rows = append(rows, database.SearchChirpsByRankRow(row))
+	}
+	return rows, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Session is a logged in device. Every session is one refresh token family,
+// so its ID stays the same across token rotations. Grants to OAuth clients
+// are sessions too and carry the client's ID.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+	ClientID   string    `json:"client_id,omitempty"`
+}
+
+func (c *apiConfig) handlerListSessions(w http.ResponseWriter, req *http.Request) {
+	principal := principalFrom(req.Context())
+	userID := principal.User.ID
+	rows, err := c.db.ListActiveSessions(req.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	sessions := make([]Session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, Session{
+			ID:         row.FamilyID,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			CreatedAt:  row.SessionStartedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			Current:    row.FamilyID == principal.SessionID,
+			ClientID:   row.ClientID.String,
+		})
+	}
+	respondWithJSON(w, 200, sessions)
+}
+
+func (c *apiConfig) handlerRevokeSession(w http.ResponseWriter, req *http.Request) {
+	principal := principalFrom(req.Context())
+	userID := principal.User.ID
+	sessionID, err := uuid.Parse(req.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid session ID")
+		return
+	}
+	revoked, err := c.db.RevokeUserSession(req.Context(), database.RevokeUserSessionParams{UserID: userID, FamilyID: sessionID})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (c *apiConfig) handlerRevokeAllSessions(w http.ResponseWriter, req *http.Request) {
+	principal := principalFrom(req.Context())
+	userID := principal.User.ID
+	err := c.db.RevokeAllUserTokens(req.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerDeleteSingleChirp_RepliedTo(t *testing.T) {
+	c := newTestConfig(t)
+	user := createTestUser(t, c)
+	ctx := withPrincipal(t.Context(), &Principal{User: user})
+
+	// Tombstones all end up with the same empty body, deleting a second
+	// replied to chirp must not trip over the first.
+	for _, body := range []string{"First chirp", "Second chirp"} {
+		chirp, err := c.db.CreateChirp(t.Context(), database.CreateChirpParams{Body: body, UserID: user.ID})
+		if err != nil {
+			t.Fatalf("Failed to create chirp: %v", err)
+		}
+		_, err = c.db.CreateChirp(t.Context(), database.CreateChirpParams{
+			Body:      "Reply to " + body,
+			UserID:    user.ID,
+			InReplyTo: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create reply: %v", err)
+		}
+
+		req := httptest.NewRequestWithContext(ctx, "DELETE", "/api/chirps/"+chirp.ID.String(), nil)
+		req.SetPathValue("chirpID", chirp.ID.String())
+		w := httptest.NewRecorder()
+		c.handlerDeleteSingleChirp(w, req)
+		if w.Code != 204 {
+			t.Fatalf("Deleting %q: expected 204, got %d: %s", body, w.Code, w.Body.String())
+		}
+
+		tombstone, err := c.db.GetThreadChirp(t.Context(), chirp.ID)
+		if err != nil {
+			t.Fatalf("Expected a tombstone for %q: %v", body, err)
+		}
+		if !tombstone.DeletedAt.Valid || tombstone.Body != "" {
+			t.Errorf("Expected %q to be a tombstone, got %+v", body, tombstone)
+		}
+	}
+}
+
+func TestVerifyPassword_TooLong(t *testing.T) {
+	c := &apiConfig{passwordPolicy: auth.DefaultPasswordPolicy()}
+	password := strings.Repeat("a", c.passwordPolicy.MaxLength+1)
+	// Even the right password is turned down, it can not have been set
+	// through the policy.
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	match, err := c.verifyPassword(t.Context(), database.User{HashedPassword: hash}, password)
+	if err != nil {
+		t.Fatalf("verifyPassword failed: %v", err)
+	}
+	if match {
+		t.Error("Expected a password over the maximum length to be rejected")
+	}
+}
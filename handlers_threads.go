@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+	// maxThreadAncestors bounds the conversation shown above a chirp. If the
+	// first ancestor has in_reply_to set, clients ask for its thread.
+	maxThreadAncestors = 100
+	// maxThreadDescendants bounds the replies below a page of direct
+	// replies. A chirp with fewer replies than its reply_count has more to
+	// show in its own thread.
+	maxThreadDescendants = 500
+)
+
+// ThreadChirp is a chirp in a conversation with the replies to it. A chirp
+// that was deleted after being replied to is a tombstone: only ID,
+// InReplyTo and Deleted are set.
+type ThreadChirp struct {
+	ID        uuid.UUID  `json:"id"`
+	InReplyTo *uuid.UUID `json:"in_reply_to"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	*Chirp
+	Replies []ThreadChirp `json:"replies,omitempty"`
+}
+
+func threadChirp(chirp database.Chirp) ThreadChirp {
+	node := ThreadChirp{ID: chirp.ID}
+	if chirp.InReplyTo.Valid {
+		node.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	if chirp.DeletedAt.Valid {
+		node.Deleted = true
+		return node
+	}
+	response := chirpResponse(chirp)
+	node.Chirp = &response
+	return node
+}
+
+func chirpFromTreeRow(row database.ListChirpAncestorsRow) database.Chirp {
+	return database.Chirp{
+		ID:         row.ID,
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt,
+		Body:       row.Body,
+		UserID:     row.UserID,
+		InReplyTo:  row.InReplyTo,
+		ReplyCount: row.ReplyCount,
+		LikeCount:  row.LikeCount,
+		DeletedAt:  row.DeletedAt,
+	}
+}
+
+// Thread is a chirp with the conversation around it.
+type Thread struct {
+	// Ancestors lead from the start of the conversation down to Chirp.
+	Ancestors []ThreadChirp `json:"ancestors"`
+	Chirp     ThreadChirp   `json:"chirp"`
+}
+
+// handlerGetThread responds with the ancestors of a chirp and one page of its
+// direct replies, each with the replies below it down to ?depth= levels.
+// Further pages of direct replies are linked in the Link header.
+func (c *apiConfig) handlerGetThread(w http.ResponseWriter, req *http.Request) {
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+	query := req.URL.Query()
+	page, err := parseForwardPageRequest(query, "Replies")
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	depth := defaultThreadDepth
+	if value := query.Get("depth"); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 1 || depth > maxThreadDepth {
+			respondWithError(w, 400, fmt.Sprintf("Depth must be between 1 and %d", maxThreadDepth))
+			return
+		}
+	}
+
+	chirp, err := c.db.GetThreadChirp(req.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	thread := Thread{Ancestors: []ThreadChirp{}, Chirp: threadChirp(chirp)}
+
+	if chirp.InReplyTo.Valid {
+		rows, err := c.db.ListChirpAncestors(req.Context(), database.ListChirpAncestorsParams{
+			ID:       chirp.InReplyTo.UUID,
+			MaxDepth: maxThreadAncestors,
+		})
+		if err != nil {
+			respondWithError(w, 500, "Database error")
+			return
+		}
+		// A parent that is not there at all went away with its author's
+		// account, it is shown as a tombstone like any other.
+		top := chirp.InReplyTo
+		if len(rows) > 0 {
+			top = rows[0].InReplyTo
+		}
+		if top.Valid && len(rows) < maxThreadAncestors {
+			thread.Ancestors = append(thread.Ancestors, ThreadChirp{ID: top.UUID, Deleted: true})
+		}
+		for _, row := range rows {
+			thread.Ancestors = append(thread.Ancestors, threadChirp(chirpFromTreeRow(row)))
+		}
+	}
+
+	arg := database.ListChirpRepliesParams{
+		InReplyTo: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		MaxRows:   page.maxRows(),
+	}
+	if page.after != nil {
+		arg.CursorCreatedAt = sql.NullTime{Time: page.after.CreatedAt, Valid: true}
+		arg.CursorID = uuid.NullUUID{UUID: page.after.ID, Valid: true}
+	}
+	replies, err := c.db.ListChirpReplies(req.Context(), arg)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	replies = setPage(c, w, req, page, replies, func(reply database.Chirp) pageCursor {
+		return pageCursor{CreatedAt: reply.CreatedAt, ID: reply.ID}
+	})
+
+	children := map[uuid.UUID][]database.Chirp{chirp.ID: replies}
+	if depth > 1 && len(replies) > 0 {
+		parentIDs := make([]uuid.UUID, 0, len(replies))
+		for _, reply := range replies {
+			parentIDs = append(parentIDs, reply.ID)
+		}
+		rows, err := c.db.ListChirpDescendants(req.Context(), database.ListChirpDescendantsParams{
+			ParentIds: parentIDs,
+			MaxDepth:  int32(depth - 1),
+			MaxRows:   maxThreadDescendants,
+		})
+		if err != nil {
+			respondWithError(w, 500, "Database error")
+			return
+		}
+		for _, row := range rows {
+			descendant := chirpFromTreeRow(database.ListChirpAncestorsRow(row))
+			children[descendant.InReplyTo.UUID] = append(children[descendant.InReplyTo.UUID], descendant)
+		}
+	}
+	thread.Chirp.Replies = threadReplies(chirp.ID, children)
+
+	likeable := threadChirps(thread.Ancestors, nil)
+	likeable = threadChirps([]ThreadChirp{thread.Chirp}, likeable)
+	if err := c.setLikedByMe(req.Context(), likeable...); err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithJSON(w, 200, thread)
+}
+
+// threadReplies builds the tree below parentID. The replies to each chirp
+// are in the order they were posted.
+func threadReplies(parentID uuid.UUID, children map[uuid.UUID][]database.Chirp) []ThreadChirp {
+	var replies []ThreadChirp
+	for _, child := range children[parentID] {
+		node := threadChirp(child)
+		node.Replies = threadReplies(child.ID, children)
+		replies = append(replies, node)
+	}
+	return replies
+}
+
+// threadChirps appends the chirps in nodes and below them to chirps,
+// leaving out tombstones.
+func threadChirps(nodes []ThreadChirp, chirps []*Chirp) []*Chirp {
+	for _, node := range nodes {
+		if node.Chirp != nil {
+			chirps = append(chirps, node.Chirp)
+		}
+		chirps = threadChirps(node.Replies, chirps)
+	}
+	return chirps
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxTokenNameLength = 100
+
+// PersonalAccessToken is a long lived token a user minted for a script or a
+// bot. The secret itself is only part of the response that created it.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func personalAccessTokenResponse(token database.PersonalAccessToken) PersonalAccessToken {
+	response := PersonalAccessToken{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if token.ExpiresAt.Valid {
+		response.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.LastUsedAt.Valid {
+		response.LastUsedAt = &token.LastUsedAt.Time
+	}
+	return response
+}
+
+func (c *apiConfig) handlerCreatePersonalAccessToken(w http.ResponseWriter, req *http.Request) {
+	userID := principalFrom(req.Context()).User.ID
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	var params parameters
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxTokenNameLength {
+		respondWithError(w, 400, fmt.Sprintf("Name must be between 1 and %d characters", maxTokenNameLength))
+		return
+	}
+	scopes, err := auth.NormalizeScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if params.ExpiresInDays < 0 {
+		respondWithError(w, 400, "expires_in_days can not be negative")
+		return
+	}
+	var expiresAt sql.NullTime
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	secret, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	token, err := c.db.CreatePersonalAccessToken(req.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      params.Name,
+		TokenHash: c.hashToken(secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	response := personalAccessTokenResponse(token)
+	response.Token = secret
+	respondWithJSON(w, 201, response)
+}
+
+func (c *apiConfig) handlerListPersonalAccessTokens(w http.ResponseWriter, req *http.Request) {
+	userID := principalFrom(req.Context()).User.ID
+	rows, err := c.db.ListPersonalAccessTokens(req.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	tokens := make([]PersonalAccessToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, personalAccessTokenResponse(row))
+	}
+	respondWithJSON(w, 200, tokens)
+}
+
+func (c *apiConfig) handlerRevokePersonalAccessToken(w http.ResponseWriter, req *http.Request) {
+	userID := principalFrom(req.Context()).User.ID
+	tokenID, err := uuid.Parse(req.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid token ID")
+		return
+	}
+	revoked, err := c.db.RevokePersonalAccessToken(req.Context(), database.RevokePersonalAccessTokenParams{ID: tokenID, UserID: userID})
+	if err != nil {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -1,10 +1,11 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -15,18 +16,19 @@ import (
 )
 
 func HashPassword(password string) (string, error) {
-	hashedPwd, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	hashedPwd, err := argon2id.CreateHash(password, passwordParams.Load())
 	if err != nil {
-		log.Fatal(err)
-		return "", err
+		return "", fmt.Errorf("hashing password: %w", err)
 	}
 	return hashedPwd, nil
 }
+
+// CheckPasswordHash reports whether password matches hash. A hash that can
+// not be parsed yields ErrMalformedHash rather than a mismatch.
 func CheckPasswordHash(password, hash string) (bool, error) {
 	match, err := argon2id.ComparePasswordAndHash(password, hash)
 	if err != nil {
-		log.Fatal(err)
-		return false, err
+		return false, fmt.Errorf("%w: %w", ErrMalformedHash, err)
 	}
 	return match, nil
 }
@@ -56,16 +58,16 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 		},
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, tokenError(err)
 	}
 	claims, ok := token.Claims.(*jwt.RegisteredClaims)
 	if !ok || !token.Valid {
-		return uuid.Nil, fmt.Errorf("invalid token claims")
+		return uuid.Nil, fmt.Errorf("%w: invalid token claims", ErrTokenInvalid)
 	}
 
 	userId, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID in token: %w", err)
+		return uuid.Nil, fmt.Errorf("%w: invalid user ID in token: %w", ErrTokenInvalid, err)
 	}
 
 	return userId, nil
@@ -75,7 +77,7 @@ func GetBearerToken(headers http.Header) (string, error) {
 	tokenString := headers.Get("Authorization")
 	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 	if tokenString == "" {
-		return "", fmt.Errorf("token does not exists")
+		return "", ErrMissingAuthHeader
 	}
 	return tokenString, nil
 
@@ -91,12 +93,20 @@ func MakeRefreshToken() (string, error) {
 	return refreshToken, nil
 }
 
+// HashToken returns the keyed hash under which an opaque token is stored, so a
+// leaked database does not leak usable tokens.
+func HashToken(token, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func GetApiKey(headers http.Header) (string, error) {
 	apiKey := headers.Get("Authorization")
 	apiKey = strings.TrimPrefix(apiKey, "ApiKey ")
 	// fmt.Printf("ApiKey: %s\n", apiKey)
 	if apiKey == "" {
-		return "", fmt.Errorf("apiKey does not exists")
+		return "", ErrMissingAuthHeader
 	}
 	return apiKey, nil
 }
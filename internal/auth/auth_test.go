@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+	"net/http"
 	"testing"
 	"time"
 
@@ -132,6 +134,9 @@ func TestValidateJWT_ExpiredToken(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error when validating expired token")
 	}
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
 }
 
 func TestValidateJWT_InvalidFormat(t *testing.T) {
@@ -142,6 +147,9 @@ func TestValidateJWT_InvalidFormat(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error when validating invalid token format")
 	}
+	if !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid, got %v", err)
+	}
 }
 
 func TestValidateJWT_EmptyToken(t *testing.T) {
@@ -179,3 +187,47 @@ func TestHashPassword_DifferentHashesForSamePassword(t *testing.T) {
 		t.Error("Both hashes should validate correctly")
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	hash := HashToken(token, "pepper")
+	if hash == token {
+		t.Error("Hash should not equal plain token")
+	}
+	if hash != HashToken(token, "pepper") {
+		t.Error("Expected the same hash for the same token and pepper")
+	}
+	if hash == HashToken(token, "other-pepper") {
+		t.Error("Expected a different hash for a different pepper")
+	}
+}
+
+func TestCheckPasswordHash_MalformedHash(t *testing.T) {
+	// 'unset' is the default hashed_password of accounts created before
+	// passwords were introduced.
+	match, err := CheckPasswordHash("anything", "unset")
+	if match {
+		t.Error("Expected malformed hash to not match")
+	}
+	if !errors.Is(err, ErrMalformedHash) {
+		t.Errorf("Expected ErrMalformedHash, got %v", err)
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer abc")
+	token, err := GetBearerToken(headers)
+	if err != nil || token != "abc" {
+		t.Errorf("Expected token abc, got %q err=%v", token, err)
+	}
+
+	_, err = GetBearerToken(http.Header{})
+	if !errors.Is(err, ErrMissingAuthHeader) {
+		t.Errorf("Expected ErrMissingAuthHeader, got %v", err)
+	}
+}
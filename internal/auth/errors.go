@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrMalformedHash means a stored password hash can not be parsed, for
+	// example the 'unset' placeholder of accounts without a password.
+	ErrMalformedHash = errors.New("malformed password hash")
+	// ErrTokenExpired means a token was valid but is past its expiry.
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrTokenInvalid covers every other reason a token is rejected.
+	ErrTokenInvalid = errors.New("token is invalid")
+	// ErrMissingAuthHeader means the request carried no credentials.
+	ErrMissingAuthHeader = errors.New("missing authorization header")
+	// ErrInsufficientScope means the credentials are valid but do not allow
+	// the requested action.
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// tokenError classifies an error returned by the jwt library.
+func tokenError(err error) error {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return fmt.Errorf("%w: %w", ErrTokenExpired, err)
+	}
+	return fmt.Errorf("%w: %w", ErrTokenInvalid, err)
+}
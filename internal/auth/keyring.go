@@ -0,0 +1,437 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// signingKey is a single private key in a KeyRing together with the JWT
+// algorithm it signs with.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// KeyRing holds every key Chirpy currently accepts for access tokens. New
+// tokens are signed with the active key, while any key still in the ring can
+// verify, so rotating to a new key does not invalidate tokens in flight.
+type KeyRing struct {
+	mu           sync.RWMutex
+	keys         map[string]*signingKey
+	active       string
+	legacySecret string
+	legacyUntil  time.Time
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]*signingKey)}
+}
+
+// AddKey adds a verification key to the ring. The first key added becomes the
+// active signing key.
+func (k *KeyRing) AddKey(kid string, key crypto.Signer) error {
+	if kid == "" {
+		return fmt.Errorf("key id can not be empty")
+	}
+	method, err := signingMethodFor(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.keys[kid]; exists {
+		return fmt.Errorf("key %q already exists", kid)
+	}
+	k.keys[kid] = &signingKey{id: kid, method: method, private: key}
+	if k.active == "" {
+		k.active = kid
+	}
+	return nil
+}
+
+// SetActive switches the key used for signing new tokens.
+func (k *KeyRing) SetActive(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("key %q does not exist", kid)
+	}
+	k.active = kid
+	return nil
+}
+
+// Rotate adds a new key and makes it active. The previous key stays in the
+// ring for verification until it is removed with RemoveKey.
+func (k *KeyRing) Rotate(kid string, key crypto.Signer) error {
+	if err := k.AddKey(kid, key); err != nil {
+		return err
+	}
+	return k.SetActive(kid)
+}
+
+// RemoveKey retires a key. Tokens signed with it stop validating, so it should
+// only be called once they have all expired.
+func (k *KeyRing) RemoveKey(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if kid == k.active {
+		return fmt.Errorf("can not remove the active key %q", kid)
+	}
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("key %q does not exist", kid)
+	}
+	delete(k.keys, kid)
+	return nil
+}
+
+// ActiveKeyID returns the kid new tokens are signed with.
+func (k *KeyRing) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// LegacyTokenLifetime is the longest lifetime an HS256 token from before the
+// switch to asymmetric keys could have had.
+const LegacyTokenLifetime = time.Hour
+
+// SetLegacySecret keeps accepting HS256 tokens without a kid that were signed
+// with the old shared secret and issued before until. Anyone who knows the
+// secret could sign such a token, so they are only plain user tokens: no
+// role, purpose, actor or client claims, and no life beyond
+// LegacyTokenLifetime past until. Pass an empty secret to stop accepting them.
+func (k *KeyRing) SetLegacySecret(secret string, until time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.legacySecret = secret
+	k.legacyUntil = until
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key, ok := k.keys[k.active]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("key ring has no active key")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	signedToken, err := token.SignedString(key.private)
+	if err != nil {
+		return "", err
+	}
+	return signedToken, nil
+}
+
+// Parse verifies the token against the key named by its kid header and
+// decodes it into claims.
+func (k *KeyRing) Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc, jwt.WithIssuer("chirpy"))
+	if err != nil {
+		return tokenError(err)
+	}
+	if !token.Valid {
+		return fmt.Errorf("%w: invalid token claims", ErrTokenInvalid)
+	}
+	if kid, _ := token.Header["kid"].(string); kid == "" {
+		return k.checkLegacyClaims(claims)
+	}
+	return nil
+}
+
+// checkLegacyClaims limits what a token signed with the legacy secret can
+// claim, see SetLegacySecret.
+func (k *KeyRing) checkLegacyClaims(claims jwt.Claims) error {
+	k.mu.RLock()
+	until := k.legacyUntil
+	k.mu.RUnlock()
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil || !issuedAt.Before(until) {
+		return fmt.Errorf("%w: legacy token issued after the key rotation", ErrTokenInvalid)
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil || expiresAt.After(until.Add(LegacyTokenLifetime)) {
+		return fmt.Errorf("%w: legacy token outlives the key rotation", ErrTokenInvalid)
+	}
+	if c, ok := claims.(*Claims); ok {
+		if c.Role != "" || c.Purpose != "" || c.Actor != nil || c.ClientID != "" || c.Scope != "" {
+			return fmt.Errorf("%w: legacy token carries privileged claims", ErrTokenInvalid)
+		}
+	}
+	return nil
+}
+
+func (k *KeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.legacySecret != "" && !k.legacyUntil.IsZero() && token.Method == jwt.SigningMethodHS256 {
+			return []byte(k.legacySecret), nil
+		}
+		return nil, fmt.Errorf("token has no key id")
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.private.Public(), nil
+}
+
+// Claims are the claims carried by Chirpy access tokens.
+type Claims struct {
+	jwt.RegisteredClaims
+	// SessionID is the refresh token family the access token was issued for.
+	SessionID string `json:"sid,omitempty"`
+	// Purpose marks tokens that are only good for one step of a flow, such
+	// as finishing a two factor login. They are never valid access tokens.
+	Purpose string `json:"purpose,omitempty"`
+	// Role is the role of the user when the token was issued. Role changes
+	// reach the claim with the next refresh.
+	Role Role `json:"role,omitempty"`
+	// ClientID is the OAuth client a delegated token was issued to. Such
+	// tokens only allow what Scope lists.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Actor is set on impersonation tokens and names who really acts, the
+	// subject is the impersonated user (RFC 8693 section 4.1).
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Actor is the party acting on behalf of the subject of a token.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// ActorID returns the user acting through an impersonation token, or
+// uuid.Nil for ordinary tokens.
+func (c *Claims) ActorID() (uuid.UUID, error) {
+	if c.Actor == nil {
+		return uuid.Nil, nil
+	}
+	actorID, err := uuid.Parse(c.Actor.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: invalid actor in token: %w", ErrTokenInvalid, err)
+	}
+	return actorID, nil
+}
+
+// PurposeMFA is the purpose of the token handed out between the password
+// and the second factor of a login.
+const PurposeMFA = "mfa"
+
+func NewClaims(userId uuid.UUID, expiresIn time.Duration) *Claims {
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userId.String(),
+		},
+	}
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	userId, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: invalid user ID in token: %w", ErrTokenInvalid, err)
+	}
+	return userId, nil
+}
+
+// UserRole returns the role claim, treating tokens issued before roles existed
+// as belonging to a plain user.
+func (c *Claims) UserRole() Role {
+	if c.Role == "" {
+		return RoleUser
+	}
+	return c.Role
+}
+
+func (k *KeyRing) MakeJWT(userId uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.Sign(NewClaims(userId, expiresIn))
+}
+
+func (k *KeyRing) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := k.ValidateClaims(tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID()
+}
+
+// ValidateClaims verifies an access token and returns all of its claims.
+func (k *KeyRing) ValidateClaims(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := k.Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("%w: %s token can not be used as an access token", ErrTokenInvalid, claims.Purpose)
+	}
+	return claims, nil
+}
+
+// MakePurposeJWT issues a short lived token that is only accepted by
+// ValidatePurposeJWT with the same purpose.
+func (k *KeyRing) MakePurposeJWT(userId uuid.UUID, purpose string, expiresIn time.Duration) (string, error) {
+	claims := NewClaims(userId, expiresIn)
+	claims.Purpose = purpose
+	return k.Sign(claims)
+}
+
+func (k *KeyRing) ValidatePurposeJWT(tokenString, purpose string) (uuid.UUID, error) {
+	claims := &Claims{}
+	if err := k.Parse(tokenString, claims); err != nil {
+		return uuid.Nil, err
+	}
+	if claims.Purpose != purpose {
+		return uuid.Nil, fmt.Errorf("%w: expected a %s token", ErrTokenInvalid, purpose)
+	}
+	return claims.UserID()
+}
+
+// JWKS returns the public half of every key in the ring, sorted by kid.
+func (k *KeyRing) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := JWKS{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+// LoadKeyRing reads every *.pem private key in dir, using the file name
+// without extension as the kid. The key named activeKid signs new tokens; when
+// activeKid is empty the last kid in lexical order is used, so date-named
+// files rotate automatically.
+func LoadKeyRing(dir, activeKid string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.pem keys found in %s", dir)
+	}
+	sort.Strings(paths)
+	ring := NewKeyRing()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if err := ring.AddKey(kid, key); err != nil {
+			return nil, err
+		}
+		if activeKid == "" {
+			ring.active = kid
+		}
+	}
+	if activeKid != "" {
+		if err := ring.SetActive(activeKid); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8 or PKCS#1 encoded RSA or Ed25519 key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if _, err := signingMethodFor(signer); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// GenerateEd25519Key creates a fresh signing key, used when no key directory
+// is configured.
+func GenerateEd25519Key() (crypto.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func signingMethodFor(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestRing(t *testing.T, kid string) *KeyRing {
+	t.Helper()
+	key, err := GenerateEd25519Key()
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	ring := NewKeyRing()
+	if err := ring.AddKey(kid, key); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	return ring
+}
+
+func TestKeyRing_ValidToken(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	userID := uuid.New()
+
+	token, err := ring.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	extractedID, err := ring.ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if extractedID != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+	}
+}
+
+func TestKeyRing_SessionClaim(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	userID := uuid.New()
+	sessionID := uuid.New()
+
+	claims := NewClaims(userID, time.Hour)
+	claims.SessionID = sessionID.String()
+	token, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	parsed, err := ring.ValidateClaims(token)
+	if err != nil {
+		t.Fatalf("ValidateClaims failed: %v", err)
+	}
+	if parsed.SessionID != sessionID.String() {
+		t.Errorf("Expected session ID %v, got %v", sessionID, parsed.SessionID)
+	}
+	if extractedID, _ := parsed.UserID(); extractedID != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+	}
+}
+
+func TestKeyRing_RoleClaim(t *testing.T) {
+	ring := newTestRing(t, "k1")
+
+	claims := NewClaims(uuid.New(), time.Hour)
+	claims.Role = RoleModerator
+	token, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	parsed, err := ring.ValidateClaims(token)
+	if err != nil {
+		t.Fatalf("ValidateClaims failed: %v", err)
+	}
+	if parsed.UserRole() != RoleModerator {
+		t.Errorf("Expected role %s, got %s", RoleModerator, parsed.UserRole())
+	}
+
+	token, _ = ring.MakeJWT(uuid.New(), time.Hour)
+	parsed, _ = ring.ValidateClaims(token)
+	if parsed.UserRole() != RoleUser {
+		t.Errorf("Expected tokens without a role to default to %s, got %s", RoleUser, parsed.UserRole())
+	}
+}
+
+func TestKeyRing_ActorClaim(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	userID := uuid.New()
+	adminID := uuid.New()
+
+	claims := NewClaims(userID, time.Hour)
+	claims.Actor = &Actor{Subject: adminID.String()}
+	token, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	parsed, err := ring.ValidateClaims(token)
+	if err != nil {
+		t.Fatalf("ValidateClaims failed: %v", err)
+	}
+	if actorID, err := parsed.ActorID(); err != nil || actorID != adminID {
+		t.Errorf("Expected actor %v, got %v (err %v)", adminID, actorID, err)
+	}
+	if extractedID, _ := parsed.UserID(); extractedID != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+	}
+
+	token, _ = ring.MakeJWT(userID, time.Hour)
+	parsed, _ = ring.ValidateClaims(token)
+	if actorID, err := parsed.ActorID(); err != nil || actorID != uuid.Nil {
+		t.Errorf("Expected no actor on an ordinary token, got %v (err %v)", actorID, err)
+	}
+
+	parsed.Actor = &Actor{Subject: "not-a-uuid"}
+	if _, err := parsed.ActorID(); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid for a malformed actor, got %v", err)
+	}
+}
+
+func TestKeyRing_PurposeTokens(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	userID := uuid.New()
+
+	mfaToken, err := ring.MakePurposeJWT(userID, PurposeMFA, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("MakePurposeJWT failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(mfaToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected purpose token to be rejected as an access token, got %v", err)
+	}
+	extractedID, err := ring.ValidatePurposeJWT(mfaToken, PurposeMFA)
+	if err != nil {
+		t.Fatalf("ValidatePurposeJWT failed: %v", err)
+	}
+	if extractedID != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+	}
+
+	accessToken, _ := ring.MakeJWT(userID, time.Hour)
+	if _, err := ring.ValidatePurposeJWT(accessToken, PurposeMFA); err == nil {
+		t.Error("Expected access token to be rejected as a purpose token")
+	}
+}
+
+func TestKeyRing_ExpiredToken(t *testing.T) {
+	ring := newTestRing(t, "k1")
+
+	token, err := ring.MakeJWT(uuid.New(), -time.Hour)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestKeyRing_RSAKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	ring := NewKeyRing()
+	if err := ring.AddKey("rsa", rsaKey); err != nil {
+		t.Fatalf("AddKey failed: %v", err)
+	}
+	userID := uuid.New()
+
+	token, err := ring.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(token); err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+
+	jwks := ring.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != "RSA" || jwks.Keys[0].Alg != "RS256" {
+		t.Errorf("Unexpected JWKS: %+v", jwks)
+	}
+}
+
+func TestKeyRing_RotationKeepsOldTokensValid(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	userID := uuid.New()
+
+	oldToken, err := ring.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	newKey, _ := GenerateEd25519Key()
+	if err := ring.Rotate("k2", newKey); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if ring.ActiveKeyID() != "k2" {
+		t.Errorf("Expected active key k2, got %s", ring.ActiveKeyID())
+	}
+
+	if _, err := ring.ValidateJWT(oldToken); err != nil {
+		t.Errorf("Old token should still validate after rotation: %v", err)
+	}
+
+	if err := ring.RemoveKey("k1"); err != nil {
+		t.Fatalf("RemoveKey failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(oldToken); err == nil {
+		t.Error("Expected error for token signed with a removed key")
+	}
+	if err := ring.RemoveKey("k2"); err == nil {
+		t.Error("Expected error when removing the active key")
+	}
+}
+
+func TestKeyRing_RejectsForeignKey(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	other := newTestRing(t, "k1")
+
+	token, err := other.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(token); err == nil {
+		t.Error("Expected error when validating a token signed by another ring")
+	}
+}
+
+func TestKeyRing_LegacySecret(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	userID := uuid.New()
+	legacyToken, err := MakeJWT(userID, "legacy-secret", time.Hour)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	if _, err := ring.ValidateJWT(legacyToken); err == nil {
+		t.Error("Expected legacy token to be rejected without a legacy secret")
+	}
+
+	ring.SetLegacySecret("legacy-secret", time.Now().Add(time.Minute))
+	extractedID, err := ring.ValidateJWT(legacyToken)
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if extractedID != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+	}
+
+	ring.SetLegacySecret("legacy-secret", time.Now().Add(-time.Minute))
+	if _, err := ring.ValidateJWT(legacyToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected a legacy token issued after the rotation to be rejected, got %v", err)
+	}
+}
+
+func TestKeyRing_LegacySecretRestrictsClaims(t *testing.T) {
+	ring := newTestRing(t, "k1")
+	now := time.Now()
+	ring.SetLegacySecret("legacy-secret", now.Add(time.Minute))
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+	}{
+		{name: "Role", modify: func(c *Claims) { c.Role = RoleAdmin }},
+		{name: "Purpose", modify: func(c *Claims) { c.Purpose = PurposeMFA }},
+		{name: "Actor", modify: func(c *Claims) { c.Actor = &Actor{Subject: uuid.NewString()} }},
+		{name: "Client", modify: func(c *Claims) { c.ClientID = "client"; c.Scope = ScopeChirpsRead }},
+		{name: "Long lifetime", modify: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(24 * time.Hour)) }},
+		{name: "No issued at", modify: func(c *Claims) { c.IssuedAt = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := NewClaims(uuid.New(), time.Hour)
+			tt.modify(claims)
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("legacy-secret"))
+			if err != nil {
+				t.Fatalf("Setup failed: %v", err)
+			}
+			if _, err := ring.ValidateClaims(token); !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("Expected ErrTokenInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	for _, kid := range []string{"2026-01-01", "2026-06-01"} {
+		key, _ := GenerateEd25519Key()
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+
+	ring, err := LoadKeyRing(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyRing failed: %v", err)
+	}
+	if ring.ActiveKeyID() != "2026-06-01" {
+		t.Errorf("Expected newest key to be active, got %s", ring.ActiveKeyID())
+	}
+	if len(ring.JWKS().Keys) != 2 {
+		t.Errorf("Expected 2 public keys, got %d", len(ring.JWKS().Keys))
+	}
+
+	ring, err = LoadKeyRing(dir, "2026-01-01")
+	if err != nil {
+		t.Fatalf("LoadKeyRing failed: %v", err)
+	}
+	if ring.ActiveKeyID() != "2026-01-01" {
+		t.Errorf("Expected configured key to be active, got %s", ring.ActiveKeyID())
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alexedwards/argon2id"
+)
+
+// PasswordParams are the tunable argon2id cost parameters. Memory is in KiB.
+type PasswordParams struct {
+	Memory      uint32 `json:"memory"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+}
+
+var passwordParams atomic.Pointer[argon2id.Params]
+
+func init() {
+	passwordParams.Store(argon2id.DefaultParams)
+}
+
+// DefaultPasswordParams returns the argon2id library defaults.
+func DefaultPasswordParams() PasswordParams {
+	return PasswordParams{
+		Memory:      argon2id.DefaultParams.Memory,
+		Iterations:  argon2id.DefaultParams.Iterations,
+		Parallelism: argon2id.DefaultParams.Parallelism,
+	}
+}
+
+// SetPasswordParams changes the parameters HashPassword uses for new hashes.
+// Existing hashes keep verifying with the parameters they were made with.
+func SetPasswordParams(p PasswordParams) error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 {
+		return fmt.Errorf("invalid argon2id parameters %s", p)
+	}
+	passwordParams.Store(&argon2id.Params{
+		Memory:      p.Memory,
+		Iterations:  p.Iterations,
+		Parallelism: p.Parallelism,
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
+	})
+	return nil
+}
+
+// CurrentPasswordParams returns the parameters new hashes are created with.
+func CurrentPasswordParams() PasswordParams {
+	p := passwordParams.Load()
+	return PasswordParams{Memory: p.Memory, Iterations: p.Iterations, Parallelism: p.Parallelism}
+}
+
+// String formats the parameters the way they appear in a PHC hash string.
+func (p PasswordParams) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+}
+
+// WeakerThan reports whether any parameter of p is below the one in q.
+func (p PasswordParams) WeakerThan(q PasswordParams) bool {
+	return p.Memory < q.Memory || p.Iterations < q.Iterations || p.Parallelism < q.Parallelism
+}
+
+// ParsePasswordParams parses the "m=...,t=...,p=..." segment of a hash.
+func ParsePasswordParams(s string) (PasswordParams, error) {
+	var p PasswordParams
+	_, err := fmt.Sscanf(s, "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil {
+		return PasswordParams{}, fmt.Errorf("invalid argon2id parameters %q", s)
+	}
+	return p, nil
+}
+
+// HashPasswordParams returns the parameters a stored hash was created with.
+func HashPasswordParams(hash string) (PasswordParams, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return PasswordParams{}, fmt.Errorf("not an argon2id hash")
+	}
+	return ParsePasswordParams(parts[3])
+}
+
+// NeedsRehash reports whether hash was created with weaker parameters than
+// the current ones and should be replaced after the next successful login.
+func NeedsRehash(hash string) (bool, error) {
+	p, err := HashPasswordParams(hash)
+	if err != nil {
+		return false, err
+	}
+	return p.WeakerThan(CurrentPasswordParams()), nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import "testing"
+
+func TestNeedsRehash(t *testing.T) {
+	t.Cleanup(func() { SetPasswordParams(DefaultPasswordParams()) })
+
+	weak := PasswordParams{Memory: 16 * 1024, Iterations: 1, Parallelism: 1}
+	if err := SetPasswordParams(weak); err != nil {
+		t.Fatalf("SetPasswordParams failed: %v", err)
+	}
+	hash, err := HashPassword("mySecurePassword123")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	params, err := HashPasswordParams(hash)
+	if err != nil {
+		t.Fatalf("HashPasswordParams failed: %v", err)
+	}
+	if params != weak {
+		t.Errorf("Expected params %v, got %v", weak, params)
+	}
+	if needs, _ := NeedsRehash(hash); needs {
+		t.Error("Hash made with the current params should not need a rehash")
+	}
+
+	if err := SetPasswordParams(PasswordParams{Memory: 32 * 1024, Iterations: 2, Parallelism: 1}); err != nil {
+		t.Fatalf("SetPasswordParams failed: %v", err)
+	}
+	if needs, _ := NeedsRehash(hash); !needs {
+		t.Error("Hash made with weaker params should need a rehash")
+	}
+
+	// Old hashes still verify after the params changed.
+	match, err := CheckPasswordHash("mySecurePassword123", hash)
+	if err != nil || !match {
+		t.Errorf("Expected old hash to still match, match=%v err=%v", match, err)
+	}
+}
+
+func TestSetPasswordParams_Invalid(t *testing.T) {
+	if err := SetPasswordParams(PasswordParams{Memory: 1024, Iterations: 0, Parallelism: 1}); err == nil {
+		t.Error("Expected error for zero iterations")
+	}
+}
+
+func TestParsePasswordParams(t *testing.T) {
+	p, err := ParsePasswordParams("m=65536,t=3,p=4")
+	if err != nil {
+		t.Fatalf("ParsePasswordParams failed: %v", err)
+	}
+	if p != (PasswordParams{Memory: 65536, Iterations: 3, Parallelism: 4}) {
+		t.Errorf("Unexpected params %v", p)
+	}
+	if p.String() != "m=65536,t=3,p=4" {
+		t.Errorf("Unexpected string %s", p)
+	}
+	if _, err := HashPasswordParams("unset"); err == nil {
+		t.Error("Expected error for a value that is not a hash")
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordPolicy decides which new passwords are acceptable.
+type PasswordPolicy struct {
+	// MinLength is counted in characters.
+	MinLength int
+	// MaxLength is counted in bytes. It bounds the work one argon2id hash
+	// of a request body can cause.
+	MaxLength int
+	// Breached are passwords known from breaches. Nil skips the check.
+	Breached *BreachedPasswords
+}
+
+// DefaultPasswordPolicy follows NIST SP 800-63B: at least 8 characters and
+// room for passphrases.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8, MaxLength: 128}
+}
+
+// PasswordViolation is one reason a password was rejected. Code is stable
+// for clients, Message is meant for people.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Validate returns every rule password breaks, or nil. email is the address
+// of the account the password is for.
+func (p PasswordPolicy) Validate(password, email string) []PasswordViolation {
+	var violations []PasswordViolation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.TooLong(password) {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+		// Nothing else is worth hashing a password of any size for.
+		return violations
+	}
+	localPart, _, _ := strings.Cut(email, "@")
+	if email != "" && (strings.EqualFold(password, email) || strings.EqualFold(password, localPart)) {
+		violations = append(violations, PasswordViolation{
+			Code:    "matches_email",
+			Message: "Password must not be your email address",
+		})
+	}
+	if p.Breached.Contains(password) {
+		violations = append(violations, PasswordViolation{
+			Code:    "breached",
+			Message: "Password appeared in a data breach, choose another one",
+		})
+	}
+	return violations
+}
+
+// TooLong reports whether password is longer than MaxLength allows. No such
+// password can be set, so one can be turned down without hashing it.
+func (p PasswordPolicy) TooLong(password string) bool {
+	return p.MaxLength > 0 && len(password) > p.MaxLength
+}
+
+// breachedPrefixLength is the number of hex digits of the SHA-1 the corpus
+// is bucketed by, the same split the Pwned Passwords range API uses.
+const breachedPrefixLength = 5
+
+// BreachedPasswords is an offline corpus of breached password hashes.
+type BreachedPasswords struct {
+	ranges map[string][]string
+	count  int
+}
+
+// LoadBreachedPasswords reads a corpus in the Pwned Passwords format: one
+// upper case SHA-1 hex digest per line, optionally followed by ":count".
+// Blank lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedPasswords{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		digest, _, _ := strings.Cut(text, ":")
+		digest = strings.ToUpper(digest)
+		if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hex digest", path, line)
+		}
+		prefix := digest[:breachedPrefixLength]
+		b.ranges[prefix] = append(b.ranges[prefix], digest[breachedPrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for prefix, suffixes := range b.ranges {
+		slices.Sort(suffixes)
+		b.ranges[prefix] = slices.Compact(suffixes)
+		b.count += len(b.ranges[prefix])
+	}
+	return b, nil
+}
+
+// Len returns the number of hashes in the corpus.
+func (b *BreachedPasswords) Len() int {
+	if b == nil {
+		return 0
+	}
+	return b.count
+}
+
+// Contains reports whether password is in the corpus. A nil corpus contains
+// nothing.
+func (b *BreachedPasswords) Contains(password string) bool {
+	if b == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, found := slices.BinarySearch(b.ranges[digest[:breachedPrefixLength]], digest[breachedPrefixLength:])
+	return found
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	tests := []struct {
+		name      string
+		password  string
+		email     string
+		wantCodes []string
+	}{
+		{name: "Good password", password: "correct horse battery", email: "walt@example.com"},
+		{name: "Empty password", password: "", email: "walt@example.com", wantCodes: []string{"too_short"}},
+		{name: "Short password", password: "abc1234", email: "walt@example.com", wantCodes: []string{"too_short"}},
+		{name: "Multibyte characters count once", password: "żółćżółć", email: "walt@example.com"},
+		{name: "Too long", password: strings.Repeat("a", 129), email: "walt@example.com", wantCodes: []string{"too_long"}},
+		{name: "Email as password", password: "Walt@Example.com", email: "walt@example.com", wantCodes: []string{"matches_email"}},
+		{name: "Local part as password", password: "walter.white", email: "walter.white@example.com", wantCodes: []string{"matches_email"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var codes []string
+			for _, v := range policy.Validate(tt.password, tt.email) {
+				codes = append(codes, v.Code)
+			}
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("Validate() codes = %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" and "123456789", the second one in lower case.
+	corpus := "# top passwords\n" +
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n" +
+		"f7c3bc1d808e04732adf679965ccc34ca7ae3441\n" +
+		"\n" +
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n"
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(corpus), 0o600); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords failed: %v", err)
+	}
+	if breached.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", breached.Len())
+	}
+	for _, password := range []string{"password", "123456789"} {
+		if !breached.Contains(password) {
+			t.Errorf("Contains(%q) = false, want true", password)
+		}
+	}
+	if breached.Contains("correct horse battery") {
+		t.Error("Contains() = true for a password that is not in the corpus")
+	}
+
+	policy := DefaultPasswordPolicy()
+	policy.Breached = breached
+	violations := policy.Validate("password", "walt@example.com")
+	if len(violations) != 1 || violations[0].Code != "breached" {
+		t.Errorf("Validate() = %v, want a breached violation", violations)
+	}
+
+	var none *BreachedPasswords
+	if none.Contains("password") {
+		t.Error("A nil corpus should contain nothing")
+	}
+
+	if err := os.WriteFile(path, []byte("not a hash\n"), 0o600); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if _, err := LoadBreachedPasswords(path); err == nil {
+		t.Error("LoadBreachedPasswords() succeeded on a malformed file")
+	}
+}
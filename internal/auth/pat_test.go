@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("MakePersonalAccessToken failed: %v", err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("Expected %q to be recognised as a personal access token", token)
+	}
+	other, _ := MakePersonalAccessToken()
+	if token == other {
+		t.Error("Expected two tokens to differ")
+	}
+
+	jwt, _ := MakeJWT(uuid.New(), "secret", time.Hour)
+	if IsPersonalAccessToken(jwt) {
+		t.Error("Expected a JWT to not be recognised as a personal access token")
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	scopes, err := NormalizeScopes([]string{ScopeProfileWrite, ScopeChirpsWrite, ScopeProfileWrite})
+	if err != nil {
+		t.Fatalf("NormalizeScopes failed: %v", err)
+	}
+	if !slices.Equal(scopes, []string{ScopeChirpsWrite, ScopeProfileWrite}) {
+		t.Errorf("Unexpected scopes %v", scopes)
+	}
+
+	if _, err := NormalizeScopes([]string{"admin"}); err == nil {
+		t.Error("Expected error for an unknown scope")
+	}
+	if _, err := NormalizeScopes(nil); err == nil {
+		t.Error("Expected error for no scopes")
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	scopes, err := ParseScope("chirps:write  chirps:read")
+	if err != nil {
+		t.Fatalf("ParseScope failed: %v", err)
+	}
+	if !slices.Equal(scopes, []string{ScopeChirpsRead, ScopeChirpsWrite}) {
+		t.Errorf("Unexpected scopes %v", scopes)
+	}
+	if _, err := ParseScope(""); err == nil {
+		t.Error("Expected error for an empty scope")
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	granted := []string{ScopeChirpsRead}
+	if !HasScope(granted, ScopeChirpsRead) {
+		t.Error("Expected granted scope to be found")
+	}
+	if HasScope(granted, ScopeChirpsWrite) {
+		t.Error("Expected missing scope to not be found")
+	}
+}
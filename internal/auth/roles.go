@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Role is the coarse level of trust of a user. Each role has every
+// permission of the roles below it.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission is a single action that needs more than being logged in.
+type Permission string
+
+const (
+	PermissionModerateChirps Permission = "chirps:moderate"
+	PermissionViewMetrics    Permission = "admin:metrics"
+	PermissionManageUsers    Permission = "users:manage"
+	PermissionResetData      Permission = "admin:reset"
+	PermissionImpersonate    Permission = "users:impersonate"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:      nil,
+	RoleModerator: {PermissionModerateChirps},
+	RoleAdmin:     {PermissionModerateChirps, PermissionViewMetrics, PermissionManageUsers, PermissionResetData, PermissionImpersonate},
+}
+
+// ParseRole validates a role read from a request or the database.
+func ParseRole(role string) (Role, error) {
+	if _, ok := rolePermissions[Role(role)]; !ok {
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+	return Role(role), nil
+}
+
+// Can reports whether the role grants permission. Unknown roles grant
+// nothing.
+func (r Role) Can(permission Permission) bool {
+	return slices.Contains(rolePermissions[r], permission)
+}
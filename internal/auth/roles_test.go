@@ -0,0 +1,36 @@
+package auth
+
+import "testing"
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []string{"user", "moderator", "admin"} {
+		if _, err := ParseRole(role); err != nil {
+			t.Errorf("ParseRole(%q) failed: %v", role, err)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("Expected error for an unknown role")
+	}
+}
+
+func TestRoleCan(t *testing.T) {
+	tests := []struct {
+		role       Role
+		permission Permission
+		want       bool
+	}{
+		{RoleUser, PermissionModerateChirps, false},
+		{RoleModerator, PermissionModerateChirps, true},
+		{RoleModerator, PermissionViewMetrics, false},
+		{RoleAdmin, PermissionModerateChirps, true},
+		{RoleAdmin, PermissionManageUsers, true},
+		{RoleModerator, PermissionImpersonate, false},
+		{RoleAdmin, PermissionImpersonate, true},
+		{Role("root"), PermissionManageUsers, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Can(tt.permission); got != tt.want {
+			t.Errorf("%s.Can(%s) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 test key from RFC 6238 appendix B.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("At %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	now := time.Now()
+	code, err := TOTPCode(secret, TOTPStep(now))
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	step, ok, err := ValidateTOTP(secret, code, now, 0)
+	if err != nil || !ok {
+		t.Fatalf("Expected current code to validate, ok=%v err=%v", ok, err)
+	}
+	if step != TOTPStep(now) {
+		t.Errorf("Expected step %d, got %d", TOTPStep(now), step)
+	}
+
+	// The same code must not be accepted twice.
+	if _, ok, _ := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("Expected replayed code to be rejected")
+	}
+
+	// One period of drift is tolerated, two are not.
+	if _, ok, _ := ValidateTOTP(secret, code, now.Add(30*time.Second), 0); !ok {
+		t.Error("Expected code from the previous period to validate")
+	}
+	if _, ok, _ := ValidateTOTP(secret, code, now.Add(90*time.Second), 0); ok {
+		t.Error("Expected code from two periods ago to be rejected")
+	}
+
+	if _, ok, _ := ValidateTOTP(secret, "000000x", now, 0); ok {
+		t.Error("Expected malformed code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "user@example.com", "ABCDEF")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?") {
+		t.Errorf("Unexpected URI: %s", uri)
+	}
+	if !strings.Contains(uri, "secret=ABCDEF") || !strings.Contains(uri, "issuer=Chirpy") {
+		t.Errorf("URI is missing parameters: %s", uri)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("Expected 10 codes, got %d", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 14 {
+			t.Errorf("Unexpected code format: %s", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code: %s", code)
+		}
+		seen[code] = true
+		if NormalizeRecoveryCode(" "+strings.ToUpper(code)+" ") != code {
+			t.Errorf("NormalizeRecoveryCode did not round trip %s", code)
+		}
+	}
+}
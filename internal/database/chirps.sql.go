@@ -7,29 +7,45 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const chirpHasReplies = `-- name: ChirpHasReplies :one
+SELECT EXISTS (SELECT 1 FROM chirps WHERE in_reply_to = $1)
+`
+
+func (q *Queries) ChirpHasReplies(ctx context.Context, inReplyTo uuid.NullUUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, chirpHasReplies, inReplyTo)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
    $1,
-   $2
+   $2,
+   $3
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count
 `
 
 type CreateChirpParams struct {
-	Body   string    `json:"body"`
-	UserID uuid.UUID `json:"user_id"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	InReplyTo uuid.NullUUID `json:"in_reply_to"`
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.InReplyTo)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +53,11 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.Search,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
 	)
 	return i, err
 }
@@ -60,13 +81,14 @@ func (q *Queries) DeleteChirpByID(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const getAllChirpsByAuthor = `-- name: GetAllChirpsByAuthor :many
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirpsByAuthor, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +102,11 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.Search,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
 		); err != nil {
 			return nil, err
 		}
@@ -94,14 +121,266 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getAllChirpsByAuthor = `-- name: GetAllChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC
+const getChirpForKeyShare = `-- name: GetChirpForKeyShare :one
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
+FOR KEY SHARE
 `
 
-func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsByAuthor, userID)
+func (q *Queries) GetChirpForKeyShare(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForKeyShare, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Search,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+	)
+	return i, err
+}
+
+const getChirpForUpdate = `-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE
+`
+
+func (q *Queries) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Search,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+	)
+	return i, err
+}
+
+const getSingleChirp = `-- name: GetSingleChirp :one
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getSingleChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Search,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+	)
+	return i, err
+}
+
+const getThreadChirp = `-- name: GetThreadChirp :one
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE id = $1
+`
+
+// Unlike GetSingleChirp this finds tombstones too.
+func (q *Queries) GetThreadChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getThreadChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Search,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+	)
+	return i, err
+}
+
+const listChirpAncestors = `-- name: ListChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, 1 AS depth
+    FROM chirps
+    WHERE id = $1
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.in_reply_to, c.reply_count, c.like_count, c.deleted_at, a.depth + 1
+    FROM chirps c
+    JOIN ancestors a ON c.id = a.in_reply_to
+    WHERE a.depth < $2
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, depth
+FROM ancestors
+ORDER BY depth DESC
+`
+
+type ListChirpAncestorsParams struct {
+	ID       uuid.UUID `json:"id"`
+	MaxDepth int32     `json:"max_depth"`
+}
+
+type ListChirpAncestorsRow struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Body       string        `json:"body"`
+	UserID     uuid.UUID     `json:"user_id"`
+	InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+	ReplyCount int32         `json:"reply_count"`
+	LikeCount  int32         `json:"like_count"`
+	DeletedAt  sql.NullTime  `json:"deleted_at"`
+	Depth      int32         `json:"depth"`
+}
+
+// Returns the chirp with the given ID and up to max_depth - 1 of the chirps
+// above it, the root first.
+func (q *Queries) ListChirpAncestors(ctx context.Context, arg ListChirpAncestorsParams) ([]ListChirpAncestorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpAncestors, arg.ID, arg.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpAncestorsRow
+	for rows.Next() {
+		var i ListChirpAncestorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.DeletedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpDescendants = `-- name: ListChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, 1 AS depth
+    FROM chirps
+    WHERE in_reply_to = ANY($1::uuid[])
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.in_reply_to, c.reply_count, c.like_count, c.deleted_at, d.depth + 1
+    FROM chirps c
+    JOIN descendants d ON c.in_reply_to = d.id
+    WHERE d.depth < $2
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, depth
+FROM descendants
+ORDER BY depth, created_at, id
+LIMIT $3
+`
+
+type ListChirpDescendantsParams struct {
+	ParentIds []uuid.UUID `json:"parent_ids"`
+	MaxDepth  int32       `json:"max_depth"`
+	MaxRows   int32       `json:"max_rows"`
+}
+
+type ListChirpDescendantsRow struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Body       string        `json:"body"`
+	UserID     uuid.UUID     `json:"user_id"`
+	InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+	ReplyCount int32         `json:"reply_count"`
+	LikeCount  int32         `json:"like_count"`
+	DeletedAt  sql.NullTime  `json:"deleted_at"`
+	Depth      int32         `json:"depth"`
+}
+
+// Returns the replies below the given chirps down to max_depth levels,
+// breadth first so that max_rows cuts off the deepest replies.
+func (q *Queries) ListChirpDescendants(ctx context.Context, arg ListChirpDescendantsParams) ([]ListChirpDescendantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpDescendants, pq.Array(arg.ParentIds), arg.MaxDepth, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpDescendantsRow
+	for rows.Next() {
+		var i ListChirpDescendantsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.DeletedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpReplies = `-- name: ListChirpReplies :many
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE in_reply_to = $1
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) > ($2, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type ListChirpRepliesParams struct {
+	InReplyTo       uuid.NullUUID `json:"in_reply_to"`
+	CursorCreatedAt sql.NullTime  `json:"cursor_created_at"`
+	CursorID        uuid.NullUUID `json:"cursor_id"`
+	MaxRows         int32         `json:"max_rows"`
+}
+
+func (q *Queries) ListChirpReplies(ctx context.Context, arg ListChirpRepliesParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpReplies,
+		arg.InReplyTo,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +394,11 @@ func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.Search,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
 		); err != nil {
 			return nil, err
 		}
@@ -129,13 +413,377 @@ func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([
 	return items, nil
 }
 
-const getSingleChirp = `-- name: GetSingleChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const listChirpsAscending = `-- name: ListChirpsAscending :many
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1)
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) > ($2, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type ListChirpsAscendingParams struct {
+	AuthorID        uuid.NullUUID `json:"author_id"`
+	CursorCreatedAt sql.NullTime  `json:"cursor_created_at"`
+	CursorID        uuid.NullUUID `json:"cursor_id"`
+	MaxRows         int32         `json:"max_rows"`
+}
+
+func (q *Queries) ListChirpsAscending(ctx context.Context, arg ListChirpsAscendingParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAscending,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Search,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsDescending = `-- name: ListChirpsDescending :many
+SELECT id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1)
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) < ($2, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListChirpsDescendingParams struct {
+	AuthorID        uuid.NullUUID `json:"author_id"`
+	CursorCreatedAt sql.NullTime  `json:"cursor_created_at"`
+	CursorID        uuid.NullUUID `json:"cursor_id"`
+	MaxRows         int32         `json:"max_rows"`
+}
+
+func (q *Queries) ListChirpsDescending(ctx context.Context, arg ListChirpsDescendingParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDescending,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Search,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirpsAscending = `-- name: SearchChirpsAscending :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count,
+       ts_rank(search, to_tsquery('english', $1))::real AS rank,
+       ts_headline('english', body, to_tsquery('english', $1),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet
+FROM chirps
+WHERE search @@ to_tsquery('english', $1)
+  AND deleted_at IS NULL
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::timestamp IS NULL
+       OR (created_at, id) > ($3, $4::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $5
+`
+
+type SearchChirpsAscendingParams struct {
+	Query           string        `json:"query"`
+	AuthorID        uuid.NullUUID `json:"author_id"`
+	CursorCreatedAt sql.NullTime  `json:"cursor_created_at"`
+	CursorID        uuid.NullUUID `json:"cursor_id"`
+	MaxRows         int32         `json:"max_rows"`
+}
+
+type SearchChirpsAscendingRow struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Body       string        `json:"body"`
+	UserID     uuid.UUID     `json:"user_id"`
+	InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+	ReplyCount int32         `json:"reply_count"`
+	LikeCount  int32         `json:"like_count"`
+	Rank       float32       `json:"rank"`
+	Snippet    string        `json:"snippet"`
+}
+
+func (q *Queries) SearchChirpsAscending(ctx context.Context, arg SearchChirpsAscendingParams) ([]SearchChirpsAscendingRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirpsAscending,
+		arg.Query,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsAscendingRow
+	for rows.Next() {
+		var i SearchChirpsAscendingRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirpsByRank = `-- name: SearchChirpsByRank :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, rank,
+       ts_headline('english', body, to_tsquery('english', $1),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet
+FROM (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count,
+           ts_rank(search, to_tsquery('english', $1))::real AS rank
+    FROM chirps
+    WHERE search @@ to_tsquery('english', $1)
+      AND deleted_at IS NULL
+      AND ($2::uuid IS NULL OR user_id = $2)
+) AS matches
+WHERE $3::uuid IS NULL
+   OR (rank, id) < ($4::real, $3)
+ORDER BY rank DESC, id DESC
+LIMIT $5
+`
+
+type SearchChirpsByRankParams struct {
+	Query      string          `json:"query"`
+	AuthorID   uuid.NullUUID   `json:"author_id"`
+	CursorID   uuid.NullUUID   `json:"cursor_id"`
+	CursorRank sql.NullFloat64 `json:"cursor_rank"`
+	MaxRows    int32           `json:"max_rows"`
+}
+
+type SearchChirpsByRankRow struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Body       string        `json:"body"`
+	UserID     uuid.UUID     `json:"user_id"`
+	InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+	ReplyCount int32         `json:"reply_count"`
+	LikeCount  int32         `json:"like_count"`
+	Rank       float32       `json:"rank"`
+	Snippet    string        `json:"snippet"`
+}
+
+// The highlight tags must match search.HighlightStart and HighlightStop.
+func (q *Queries) SearchChirpsByRank(ctx context.Context, arg SearchChirpsByRankParams) ([]SearchChirpsByRankRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirpsByRank,
+		arg.Query,
+		arg.AuthorID,
+		arg.CursorID,
+		arg.CursorRank,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsByRankRow
+	for rows.Next() {
+		var i SearchChirpsByRankRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirpsDescending = `-- name: SearchChirpsDescending :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count,
+       ts_rank(search, to_tsquery('english', $1))::real AS rank,
+       ts_headline('english', body, to_tsquery('english', $1),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet
+FROM chirps
+WHERE search @@ to_tsquery('english', $1)
+  AND deleted_at IS NULL
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::timestamp IS NULL
+       OR (created_at, id) < ($3, $4::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $5
+`
+
+type SearchChirpsDescendingParams struct {
+	Query           string        `json:"query"`
+	AuthorID        uuid.NullUUID `json:"author_id"`
+	CursorCreatedAt sql.NullTime  `json:"cursor_created_at"`
+	CursorID        uuid.NullUUID `json:"cursor_id"`
+	MaxRows         int32         `json:"max_rows"`
+}
+
+type SearchChirpsDescendingRow struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Body       string        `json:"body"`
+	UserID     uuid.UUID     `json:"user_id"`
+	InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+	ReplyCount int32         `json:"reply_count"`
+	LikeCount  int32         `json:"like_count"`
+	Rank       float32       `json:"rank"`
+	Snippet    string        `json:"snippet"`
+}
+
+func (q *Queries) SearchChirpsDescending(ctx context.Context, arg SearchChirpsDescendingParams) ([]SearchChirpsDescendingRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirpsDescending,
+		arg.Query,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsDescendingRow
+	for rows.Next() {
+		var i SearchChirpsDescendingRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getSingleChirp, id)
+// A tombstone keeps the place of a deleted chirp in its thread, nothing of
+// what it said.
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, search, in_reply_to, reply_count, deleted_at, like_count
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID `json:"id"`
+	Body string    `json:"body"`
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -143,6 +791,11 @@ func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, erro
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.Search,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
 	)
 	return i, err
 }
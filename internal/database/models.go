@@ -11,28 +11,171 @@ import (
 	"github.com/google/uuid"
 )
 
-type Chirp struct {
-	ID        uuid.UUID `json:"id"`
+type AuditLog struct {
+	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
+	ActorID   uuid.UUID `json:"actor_id"`
 	UserID    uuid.UUID `json:"user_id"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail"`
 }
 
-type RefreshToken struct {
-	Token     string       `json:"token"`
+type Chirp struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Body       string        `json:"body"`
+	UserID     uuid.UUID     `json:"user_id"`
+	Search     interface{}   `json:"search"`
+	InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+	ReplyCount int32         `json:"reply_count"`
+	DeletedAt  sql.NullTime  `json:"deleted_at"`
+	LikeCount  int32         `json:"like_count"`
+}
+
+type ChirpLike struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ChirpRevision struct {
+	ID         int64     `json:"id"`
+	ChirpID    uuid.UUID `json:"chirp_id"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type EmailVerificationToken struct {
+	TokenHash string       `json:"token_hash"`
 	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
 	UserID    uuid.UUID    `json:"user_id"`
+	Email     string       `json:"email"`
 	ExpiresAt time.Time    `json:"expires_at"`
-	RevokedAt sql.NullTime `json:"revoked_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+}
+
+type LoginAttempt struct {
+	Key           string    `json:"key"`
+	Failures      int32     `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    uuid.UUID    `json:"user_id"`
+	CodeHash  string       `json:"code_hash"`
+	UsedAt    sql.NullTime `json:"used_at"`
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string       `json:"code_hash"`
+	CreatedAt     time.Time    `json:"created_at"`
+	ClientID      string       `json:"client_id"`
+	UserID        uuid.UUID    `json:"user_id"`
+	RedirectUri   string       `json:"redirect_uri"`
+	Scopes        []string     `json:"scopes"`
+	CodeChallenge string       `json:"code_challenge"`
+	ExpiresAt     time.Time    `json:"expires_at"`
+	UsedAt        sql.NullTime `json:"used_at"`
+}
+
+type OauthClient struct {
+	ID           string         `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	OwnerID      uuid.UUID      `json:"owner_id"`
+	Name         string         `json:"name"`
+	SecretHash   sql.NullString `json:"secret_hash"`
+	RedirectUris []string       `json:"redirect_uris"`
+	Scopes       []string       `json:"scopes"`
+}
+
+type OidcLoginState struct {
+	StateHash    string    `json:"state_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type PasswordResetToken struct {
+	TokenHash string       `json:"token_hash"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    uuid.UUID    `json:"user_id"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID    `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UserID     uuid.UUID    `json:"user_id"`
+	Name       string       `json:"name"`
+	TokenHash  string       `json:"token_hash"`
+	Scopes     []string     `json:"scopes"`
+	ExpiresAt  sql.NullTime `json:"expires_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	RevokedAt  sql.NullTime `json:"revoked_at"`
+}
+
+type RefreshToken struct {
+	TokenHash        string         `json:"token_hash"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	UserID           uuid.UUID      `json:"user_id"`
+	ExpiresAt        time.Time      `json:"expires_at"`
+	RevokedAt        sql.NullTime   `json:"revoked_at"`
+	FamilyID         uuid.UUID      `json:"family_id"`
+	ReplacedBy       sql.NullString `json:"replaced_by"`
+	UserAgent        string         `json:"user_agent"`
+	IpAddress        string         `json:"ip_address"`
+	SessionStartedAt time.Time      `json:"session_started_at"`
+	LastUsedAt       time.Time      `json:"last_used_at"`
+	ClientID         sql.NullString `json:"client_id"`
+	Scopes           []string       `json:"scopes"`
 }
 
 type User struct {
-	ID             uuid.UUID `json:"id"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"hashed_password"`
-	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	ID              uuid.UUID      `json:"id"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	Email           string         `json:"email"`
+	HashedPassword  string         `json:"hashed_password"`
+	IsChirpyRed     bool           `json:"is_chirpy_red"`
+	TotpSecret      sql.NullString `json:"totp_secret"`
+	TotpEnabledAt   sql.NullTime   `json:"totp_enabled_at"`
+	TotpLastStep    int64          `json:"totp_last_step"`
+	EmailVerifiedAt sql.NullTime   `json:"email_verified_at"`
+	PendingEmail    sql.NullString `json:"pending_email"`
+	Role            string         `json:"role"`
+	DeleteAfter     sql.NullTime   `json:"delete_after"`
+}
+
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+}
+
+type WebauthnChallenge struct {
+	ChallengeHash string        `json:"challenge_hash"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UserID        uuid.NullUUID `json:"user_id"`
+	Ceremony      string        `json:"ceremony"`
+	ExpiresAt     time.Time     `json:"expires_at"`
+}
+
+type WebauthnCredential struct {
+	ID         []byte       `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UserID     uuid.UUID    `json:"user_id"`
+	Name       string       `json:"name"`
+	PublicKey  []byte       `json:"public_key"`
+	SignCount  int64        `json:"sign_count"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
 }
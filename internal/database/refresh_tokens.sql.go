@@ -7,51 +7,304 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id,expires_at,revoked_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id,expires_at,revoked_at,family_id,user_agent,ip_address,session_started_at,last_used_at,client_id,scopes)
 VALUES (
     $1,
     NOW(),
     NOW(),
    $2, 
    $3,
-   NULL
+   NULL,
+   $4,
+   $5,
+   $6,
+   $7,
+   NOW(),
+   $8,
+   $9
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, session_started_at, last_used_at, client_id, scopes
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	TokenHash        string         `json:"token_hash"`
+	UserID           uuid.UUID      `json:"user_id"`
+	ExpiresAt        time.Time      `json:"expires_at"`
+	FamilyID         uuid.UUID      `json:"family_id"`
+	UserAgent        string         `json:"user_agent"`
+	IpAddress        string         `json:"ip_address"`
+	SessionStartedAt time.Time      `json:"session_started_at"`
+	ClientID         sql.NullString `json:"client_id"`
+	Scopes           []string       `json:"scopes"`
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.SessionStartedAt,
+		arg.ClientID,
+		pq.Array(arg.Scopes),
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.SessionStartedAt,
+		&i.LastUsedAt,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
 
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address, session_started_at, last_used_at, client_id, scopes FROM refresh_tokens
+WHERE token_hash = $1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.SessionStartedAt,
+		&i.LastUsedAt,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const listActiveSessions = `-- name: ListActiveSessions :many
+SELECT family_id, user_agent, ip_address, session_started_at, last_used_at, expires_at, client_id FROM refresh_tokens
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND expires_at > NOW()
+ORDER BY last_used_at DESC
+`
+
+type ListActiveSessionsRow struct {
+	FamilyID         uuid.UUID      `json:"family_id"`
+	UserAgent        string         `json:"user_agent"`
+	IpAddress        string         `json:"ip_address"`
+	SessionStartedAt time.Time      `json:"session_started_at"`
+	LastUsedAt       time.Time      `json:"last_used_at"`
+	ExpiresAt        time.Time      `json:"expires_at"`
+	ClientID         sql.NullString `json:"client_id"`
+}
+
+func (q *Queries) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]ListActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListActiveSessionsRow
+	for rows.Next() {
+		var i ListActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.SessionStartedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.ClientID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSessionHistory = `-- name: ListSessionHistory :many
+SELECT DISTINCT ON (family_id) family_id, user_agent, ip_address, session_started_at, last_used_at, expires_at, revoked_at, client_id FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY family_id, created_at DESC
+`
+
+type ListSessionHistoryRow struct {
+	FamilyID         uuid.UUID      `json:"family_id"`
+	UserAgent        string         `json:"user_agent"`
+	IpAddress        string         `json:"ip_address"`
+	SessionStartedAt time.Time      `json:"session_started_at"`
+	LastUsedAt       time.Time      `json:"last_used_at"`
+	ExpiresAt        time.Time      `json:"expires_at"`
+	RevokedAt        sql.NullTime   `json:"revoked_at"`
+	ClientID         sql.NullString `json:"client_id"`
+}
+
+func (q *Queries) ListSessionHistory(ctx context.Context, userID uuid.UUID) ([]ListSessionHistoryRow, error) {
+	rows, err := q.db.QueryContext(ctx, listSessionHistory, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSessionHistoryRow
+	for rows.Next() {
+		var i ListSessionHistoryRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.SessionStartedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ClientID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllUserTokens = `-- name: RevokeAllUserTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllUserTokens, userID)
+	return err
+}
+
+const revokeOtherUserTokens = `-- name: RevokeOtherUserTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND family_id <> $2
+  AND revoked_at IS NULL
+`
+
+type RevokeOtherUserTokensParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	FamilyID uuid.UUID `json:"family_id"`
+}
+
+func (q *Queries) RevokeOtherUserTokens(ctx context.Context, arg RevokeOtherUserTokensParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherUserTokens, arg.UserID, arg.FamilyID)
+	return err
+}
+
 const revokeToken = `-- name: RevokeToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
+WHERE token_hash = $1
 `
 
-func (q *Queries) RevokeToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeToken, token)
+func (q *Queries) RevokeToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeToken, tokenHash)
 	return err
 }
+
+const revokeTokenFamily = `-- name: RevokeTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeTokenFamily, familyID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND family_id = $2
+  AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	FamilyID uuid.UUID `json:"family_id"`
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW(), replaced_by = $2
+WHERE token_hash = $1
+  AND revoked_at IS NULL
+  AND expires_at > NOW()
+`
+
+type RotateRefreshTokenParams struct {
+	TokenHash  string         `json:"token_hash"`
+	ReplacedBy sql.NullString `json:"replaced_by"`
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, arg.TokenHash, arg.ReplacedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sessionIsActive = `-- name: SessionIsActive :one
+SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL)
+`
+
+func (q *Queries) SessionIsActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, sessionIsActive, familyID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
@@ -7,10 +7,101 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1
+  AND delete_after IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const confirmUserEmail = `-- name: ConfirmUserEmail :one
+UPDATE users
+SET email = $2,
+    email_verified_at = NOW(),
+    pending_email = CASE WHEN pending_email = $2 THEN NULL ELSE pending_email END,
+    updated_at = NOW()
+WHERE id = $1
+  AND (email = $2 OR pending_email = $2)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, pending_email, role, delete_after
+`
+
+type ConfirmUserEmailParams struct {
+	ID    uuid.UUID `json:"id"`
+	Email string    `json:"email"`
+}
+
+func (q *Queries) ConfirmUserEmail(ctx context.Context, arg ConfirmUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, confirmUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const countUsersByPasswordParams = `-- name: CountUsersByPasswordParams :many
+SELECT split_part(hashed_password, '$', 4)::text AS params, COUNT(*) AS users
+FROM users
+GROUP BY params
+ORDER BY users DESC
+`
+
+type CountUsersByPasswordParamsRow struct {
+	Params string `json:"params"`
+	Users  int64  `json:"users"`
+}
+
+func (q *Queries) CountUsersByPasswordParams(ctx context.Context) ([]CountUsersByPasswordParamsRow, error) {
+	rows, err := q.db.QueryContext(ctx, countUsersByPasswordParams)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountUsersByPasswordParamsRow
+	for rows.Next() {
+		var i CountUsersByPasswordParamsRow
+		if err := rows.Scan(
+			&i.Params,
+			&i.Users,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email,hashed_password)
 VALUES (
@@ -20,7 +111,7 @@ VALUES (
    $1, 
    $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, pending_email, role, delete_after
 `
 
 type CreateUserParams struct {
@@ -38,6 +129,13 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
@@ -51,8 +149,45 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	return err
+}
+
+const disableTOTP = `-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTOTP, id)
+	return err
+}
+
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type EnableTOTPParams struct {
+	ID           uuid.UUID `json:"id"`
+	TotpLastStep int64     `json:"totp_last_step"`
+}
+
+func (q *Queries) EnableTOTP(ctx context.Context, arg EnableTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, arg.ID, arg.TotpLastStep)
+	return err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, pending_email, role, delete_after FROM users
 WHERE email = $1
 `
 
@@ -66,20 +201,53 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, pending_email, role, delete_after FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red FROM users
+SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red, users.totp_secret, users.totp_enabled_at, users.totp_last_step, users.email_verified_at, users.pending_email, users.role, users.delete_after FROM users
 INNER JOIN refresh_tokens ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1
+WHERE refresh_tokens.token_hash = $1
   AND refresh_tokens.expires_at > NOW()
   AND refresh_tokens.revoked_at IS NULL
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+func (q *Queries) GetUserFromRefreshToken(ctx context.Context, tokenHash string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, tokenHash)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -88,6 +256,107 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Us
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE delete_after <= NOW()
+`
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedUsers)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type ScheduleUserDeletionParams struct {
+	ID          uuid.UUID    `json:"id"`
+	DeleteAfter sql.NullTime `json:"delete_after"`
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleUserDeletion, arg.ID, arg.DeleteAfter)
+	return err
+}
+
+const setPendingEmail = `-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetPendingEmailParams struct {
+	ID           uuid.UUID      `json:"id"`
+	PendingEmail sql.NullString `json:"pending_email"`
+}
+
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) error {
+	_, err := q.db.ExecContext(ctx, setPendingEmail, arg.ID, arg.PendingEmail)
+	return err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled_at = NULL, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID      `json:"id"`
+	TotpSecret sql.NullString `json:"totp_secret"`
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, pending_email, role, delete_after
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID `json:"id"`
+	Role string    `json:"role"`
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
@@ -96,7 +365,7 @@ const updateUserData = `-- name: UpdateUserData :one
 UPDATE users
 SET email = $1, hashed_password = $2, updated_at = NOW()
 WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, pending_email, role, delete_after
 `
 
 type UpdateUserDataParams struct {
@@ -115,10 +384,51 @@ func (q *Queries) UpdateUserData(ctx context.Context, arg UpdateUserDataParams)
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID `json:"id"`
+	HashedPassword string    `json:"hashed_password"`
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const upgradePasswordHash = `-- name: UpgradePasswordHash :exec
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2
+  AND hashed_password = $3
+`
+
+type UpgradePasswordHashParams struct {
+	NewHash string    `json:"new_hash"`
+	ID      uuid.UUID `json:"id"`
+	OldHash string    `json:"old_hash"`
+}
+
+func (q *Queries) UpgradePasswordHash(ctx context.Context, arg UpgradePasswordHashParams) error {
+	_, err := q.db.ExecContext(ctx, upgradePasswordHash, arg.NewHash, arg.ID, arg.OldHash)
+	return err
+}
+
 const upgradeUserToChirpyRed = `-- name: UpgradeUserToChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = true, updated_at = NOW() 
@@ -129,3 +439,23 @@ func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) erro
 	_, err := q.db.ExecContext(ctx, upgradeUserToChirpyRed, id)
 	return err
 }
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1
+  AND totp_last_step < $2
+`
+
+type UseTOTPStepParams struct {
+	ID           uuid.UUID `json:"id"`
+	TotpLastStep int64     `json:"totp_last_step"`
+}
+
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTOTPStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
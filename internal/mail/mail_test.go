@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer_Send(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer(&buf, "chirpy@example.com")
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hello", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"From: chirpy@example.com\r\n", "To: user@example.com\r\n", "Subject: Hello\r\n", "line one\r\nline two"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got %q", want, out)
+		}
+	}
+}
+
+func TestLogMailer_RejectsHeaderInjection(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer(&buf, "chirpy@example.com")
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hello"})
+	if err == nil {
+		t.Error("Expected error for a header containing a line break")
+	}
+	if buf.Len() != 0 {
+		t.Error("Nothing should be written for a rejected message")
+	}
+}
+
+func TestFileMailer_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer, err := NewFileMailer(path, "chirpy@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %v", err)
+	}
+
+	for _, subject := range []string{"First", "Second"} {
+		if err := mailer.Send(context.Background(), Message{To: "user@example.com", Subject: subject}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(data), "Subject: First") || !strings.Contains(string(data), "Subject: Second") {
+		t.Errorf("Expected both messages in the file, got %q", data)
+	}
+}
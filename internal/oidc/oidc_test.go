@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID provider that hands out ID tokens for a
+// single authorization code.
+type mockProvider struct {
+	t         *testing.T
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	kid       string
+	code      string
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	m := &mockProvider{t: t, key: key, kid: "k1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": m.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		clientID, secret, _ := req.BasicAuth()
+		if req.Form.Get("code") != m.code || CodeChallenge(req.Form.Get("code_verifier")) != m.challenge ||
+			clientID != "chirpy" || secret != "s3cret" {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": m.idToken()})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) idToken() string {
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            "chirpy",
+		"sub":            "user-1",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          m.nonce,
+		"email":          "walt@example.com",
+		"email_verified": true,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatalf("Signing failed: %v", err)
+	}
+	return signed
+}
+
+func (m *mockProvider) provider(t *testing.T) *Provider {
+	t.Helper()
+	p, err := NewProvider(Config{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     "chirpy",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8080/api/auth/mock/callback",
+	}, m.server.Client())
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	return p
+}
+
+// authorize plays the user approving the login and returns the code the
+// provider redirects back with.
+func (m *mockProvider) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid auth URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "chirpy" {
+		t.Fatalf("Unexpected auth URL %s", authURL)
+	}
+	m.code = "code-1"
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+	return m.code
+}
+
+func TestProvider_Login(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+
+	verifier, _ := NewRandomString()
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	code := m.authorize(t, authURL)
+
+	claims, err := p.Exchange(ctx, code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "walt@example.com" || !bool(claims.EmailVerified) {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+}
+
+func TestProvider_ExchangeRejectsWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+
+	verifier, _ := NewRandomString()
+	authURL, _ := p.AuthCodeURL(ctx, "state", "nonce-1", verifier)
+	code := m.authorize(t, authURL)
+
+	if _, err := p.Exchange(ctx, code, "not-the-verifier", "nonce-1"); err == nil {
+		t.Error("Expected error for a wrong PKCE verifier")
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+	m.nonce = "nonce-1"
+
+	if _, err := p.VerifyIDToken(ctx, m.idToken(), "nonce-1"); err != nil {
+		t.Fatalf("VerifyIDToken failed: %v", err)
+	}
+	if _, err := p.VerifyIDToken(ctx, m.idToken(), "other-nonce"); err == nil {
+		t.Error("Expected error for a nonce mismatch")
+	}
+
+	m.claims = jwt.MapClaims{"aud": "someone-else"}
+	if _, err := p.VerifyIDToken(ctx, m.idToken(), "nonce-1"); err == nil {
+		t.Error("Expected error for a foreign audience")
+	}
+
+	m.claims = jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}
+	if _, err := p.VerifyIDToken(ctx, m.idToken(), "nonce-1"); err == nil {
+		t.Error("Expected error for an expired token")
+	}
+
+	m.claims = jwt.MapClaims{"email_verified": "true"}
+	claims, err := p.VerifyIDToken(ctx, m.idToken(), "nonce-1")
+	if err != nil || !bool(claims.EmailVerified) {
+		t.Errorf("Expected string email_verified to be accepted, got %v", err)
+	}
+}
+
+func TestProvider_KeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider(t)
+	ctx := context.Background()
+	m.nonce = "nonce-1"
+
+	if _, err := p.VerifyIDToken(ctx, m.idToken(), "nonce-1"); err != nil {
+		t.Fatalf("VerifyIDToken failed: %v", err)
+	}
+
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	m.key, m.kid = newKey, "k2"
+	if _, err := p.VerifyIDToken(ctx, m.idToken(), "nonce-1"); err != nil {
+		t.Errorf("Expected keys to be refetched for a new kid: %v", err)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example from RFC 7636 appendix B.
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected challenge %s", got)
+	}
+}
@@ -0,0 +1,116 @@
+// Package search turns what people type into a search box into Postgres
+// full-text queries and makes the results safe to show.
+package search
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// MaxQueryLength bounds a search query in bytes.
+const MaxQueryLength = 256
+
+var (
+	ErrEmptyQuery   = errors.New("search query has no words")
+	ErrQueryTooLong = errors.New("search query is too long")
+)
+
+// ParseQuery translates q into to_tsquery syntax. It understands
+//
+//   - words, all of which must match,
+//   - "quoted phrases", whose words must appear next to each other in order,
+//   - word*, matching every word that starts with word,
+//   - -word and -"phrase", excluding matches,
+//   - OR between two terms, matching either. AND binds tighter than OR.
+//
+// Punctuation only separates words, no input can produce a malformed
+// tsquery. A missing closing quote ends the phrase at the end of q.
+func ParseQuery(q string) (string, error) {
+	if len(q) > MaxQueryLength {
+		return "", ErrQueryTooLong
+	}
+	var b strings.Builder
+	op := " & "
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		negate := false
+		if len(rest) > 1 && rest[0] == '-' {
+			negate = true
+			rest = rest[1:]
+		}
+		var raw string
+		phrase := rest[0] == '"'
+		if phrase {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				raw, rest = rest[1:], ""
+			} else {
+				raw, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			raw, rest = rest[:end], rest[end:]
+		}
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+
+		if !phrase && !negate && raw == "OR" && b.Len() > 0 {
+			op = " | "
+			continue
+		}
+		term := termQuery(raw, !phrase && strings.HasSuffix(raw, "*"))
+		if term == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(op)
+		}
+		if negate {
+			b.WriteByte('!')
+		}
+		b.WriteString(term)
+		op = " & "
+	}
+	if b.Len() == 0 {
+		return "", ErrEmptyQuery
+	}
+	return b.String(), nil
+}
+
+// termQuery returns the words of raw as a phrase, or "" if it has none.
+func termQuery(raw string, prefix bool) string {
+	words := strings.FieldsFunc(strings.ToLower(raw), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+// The tags ts_headline is told to put around matches.
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+// Snippet escapes a ts_headline result for HTML. Only the highlight tags
+// survive, markup written by the author of a chirp is shown as text.
+func Snippet(headline string) string {
+	return snippetReplacer.Replace(html.EscapeString(headline))
+}
+
+var snippetReplacer = strings.NewReplacer(
+	html.EscapeString(HighlightStart), HighlightStart,
+	html.EscapeString(HighlightStop), HighlightStop,
+)
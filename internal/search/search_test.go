@@ -0,0 +1,53 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr error
+	}{
+		{name: "Single word", query: "kerfuffle", want: "kerfuffle"},
+		{name: "Words are lower cased", query: "Sharbert", want: "sharbert"},
+		{name: "All words must match", query: "big  kerfuffle", want: "big & kerfuffle"},
+		{name: "Phrase", query: `"big kerfuffle" today`, want: "(big <-> kerfuffle) & today"},
+		{name: "Unclosed phrase", query: `today "big kerfuffle`, want: "today & (big <-> kerfuffle)"},
+		{name: "Prefix", query: "kerf*", want: "kerf:*"},
+		{name: "Negation", query: `chirpy -kerfuffle -"fornax sharbert"`, want: "chirpy & !kerfuffle & !(fornax <-> sharbert)"},
+		{name: "OR", query: "kerfuffle OR sharbert fornax", want: "kerfuffle | sharbert & fornax"},
+		{name: "Lower case or is a word", query: "kerfuffle or sharbert", want: "kerfuffle & or & sharbert"},
+		{name: "Leading OR is a word", query: "OR kerfuffle", want: "or & kerfuffle"},
+		{name: "Punctuation splits words", query: "e-mail", want: "(e <-> mail)"},
+		{name: "Operators are not passed through", query: `a&b|!c:*(d) 'e'`, want: "(a <-> b <-> c <-> d) & e"},
+		{name: "Lone minus", query: "- kerfuffle", want: "kerfuffle"},
+		{name: "Unicode", query: "żółć", want: "żółć"},
+		{name: "Empty", query: "   ", wantErr: ErrEmptyQuery},
+		{name: "Only punctuation", query: `!!! "" *`, wantErr: ErrEmptyQuery},
+		{name: "Too long", query: strings.Repeat("a", MaxQueryLength+1), wantErr: ErrQueryTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseQuery() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	headline := `I had a <mark>kerfuffle</mark> with <script>alert(1)</script> & "friends"`
+	want := `I had a <mark>kerfuffle</mark> with &lt;script&gt;alert(1)&lt;/script&gt; &amp; &#34;friends&#34;`
+	if got := Snippet(headline); got != want {
+		t.Errorf("Snippet() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+)
+
+// PostgresStore keeps counters in the login_attempts table so every
+// instance behind a load balancer sees the same failures.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (p *PostgresStore) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, allow func(State) bool) (State, bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return State{}, false, err
+	}
+	defer tx.Rollback()
+	q := database.New(tx)
+	// The row has to exist before it can be locked, or concurrent first
+	// attempts would not wait for each other.
+	err = q.CreateLoginAttempt(ctx, database.CreateLoginAttemptParams{Key: key, LastFailureAt: now.UTC()})
+	if err != nil {
+		return State{}, false, err
+	}
+	attempt, err := q.GetLoginAttemptForUpdate(ctx, key)
+	if err != nil {
+		return State{}, false, err
+	}
+	state := State{Failures: int(attempt.Failures), LastFailureAt: attempt.LastFailureAt}
+	if now.Sub(state.LastFailureAt) >= window {
+		state = State{}
+	}
+	if !allow(state) {
+		return state, false, nil
+	}
+	// The column keeps microseconds, Release matches on the stored time.
+	state = State{Failures: state.Failures + 1, LastFailureAt: now.UTC().Truncate(time.Microsecond)}
+	err = q.UpdateLoginAttempt(ctx, database.UpdateLoginAttemptParams{
+		Failures:      int32(state.Failures),
+		LastFailureAt: state.LastFailureAt,
+		Key:           key,
+	})
+	if err != nil {
+		return State{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return State{}, false, err
+	}
+	return state, true, nil
+}
+
+func (p *PostgresStore) Release(ctx context.Context, key string, reservedAt, previousAt time.Time) error {
+	return database.New(p.db).ReleaseLoginAttempt(ctx, database.ReleaseLoginAttemptParams{
+		ReservedAt: reservedAt.UTC(),
+		PreviousAt: previousAt.UTC(),
+		Key:        key,
+	})
+}
+
+func (p *PostgresStore) Reset(ctx context.Context, key string) error {
+	return database.New(p.db).DeleteLoginAttempt(ctx, key)
+}
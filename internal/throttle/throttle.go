@@ -0,0 +1,169 @@
+// Package throttle slows down and locks out repeated failed attempts, such as
+// password guesses, keyed by account or client address.
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// State is what a Store remembers about one key.
+type State struct {
+	Failures      int
+	LastFailureAt time.Time
+}
+
+// Store keeps failure counters. Implementations must make Reserve atomic so
+// concurrent attempts each see the ones before them.
+type Store interface {
+	// Reserve counts an attempt at now as a failure if allow reports true
+	// for the current state, and returns the state after it. A key whose
+	// last failure is older than window starts counting from zero again.
+	Reserve(ctx context.Context, key string, now time.Time, window time.Duration, allow func(State) bool) (State, bool, error)
+	// Release takes back one attempt counted at reservedAt. Unless a later
+	// attempt was counted since, the last failure goes back to previousAt.
+	Release(ctx context.Context, key string, reservedAt, previousAt time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+// Reservation is an attempt counted by Limiter.Reserve.
+type Reservation struct {
+	key        string
+	reservedAt time.Time
+	previousAt time.Time
+}
+
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the first delay, doubled with every further failure up
+	// to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold failures lock the key for LockoutDuration.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long failures are remembered.
+	Window time.Duration
+}
+
+// RetryAfter returns how long the key in state s has to wait before it may
+// try again, or zero when it may try right away.
+func (p Policy) RetryAfter(s State, now time.Time) time.Duration {
+	if s.Failures == 0 || now.Sub(s.LastFailureAt) >= p.Window {
+		return 0
+	}
+	var delay time.Duration
+	switch {
+	case p.LockoutThreshold > 0 && s.Failures >= p.LockoutThreshold:
+		delay = p.LockoutDuration
+	case s.Failures > p.FreeAttempts:
+		delay = p.BaseDelay
+		for i := p.FreeAttempts + 1; i < s.Failures && delay < p.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, p.MaxDelay)
+	}
+	return max(s.LastFailureAt.Add(delay).Sub(now), 0)
+}
+
+// Limiter applies a Policy to the counters in a Store.
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy, now: time.Now}
+}
+
+// Reserve counts an attempt as failed before it is made, unless key has to
+// wait first. It returns the wait, or zero when the attempt may go ahead.
+// Checking and counting in one step keeps a burst of parallel attempts from
+// all passing the check before any of them fails. Attempts that turn out to
+// succeed are taken back with Release or Succeed.
+func (l *Limiter) Reserve(ctx context.Context, key string) (Reservation, time.Duration, error) {
+	now := l.now()
+	var wait time.Duration
+	var previousAt time.Time
+	state, ok, err := l.store.Reserve(ctx, key, now, l.policy.Window, func(s State) bool {
+		previousAt = s.LastFailureAt
+		wait = l.policy.RetryAfter(s, now)
+		return wait == 0
+	})
+	if err != nil || !ok {
+		return Reservation{}, wait, err
+	}
+	return Reservation{key: key, reservedAt: state.LastFailureAt, previousAt: previousAt}, 0, nil
+}
+
+// Release takes back an attempt counted by Reserve that did not fail, as if
+// it had never been made.
+func (l *Limiter) Release(ctx context.Context, r Reservation) error {
+	if r.key == "" {
+		return nil
+	}
+	return l.store.Release(ctx, r.key, r.reservedAt, r.previousAt)
+}
+
+// Succeed forgets the failures of key.
+func (l *Limiter) Succeed(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
+
+// MemoryStore keeps counters in process memory. It is only correct when a
+// single instance serves all requests.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]State)}
+}
+
+func (m *MemoryStore) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, allow func(State) bool) (State, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.states[key]
+	if now.Sub(state.LastFailureAt) >= window {
+		state = State{}
+	}
+	if !allow(state) {
+		return state, false, nil
+	}
+	state.Failures++
+	state.LastFailureAt = now
+	m.states[key] = state
+	// Drop expired keys now and then so the map does not grow forever.
+	if len(m.states)%1024 == 0 {
+		for k, s := range m.states {
+			if now.Sub(s.LastFailureAt) >= window {
+				delete(m.states, k)
+			}
+		}
+	}
+	return state, true, nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key string, reservedAt, previousAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state, ok := m.states[key]; ok && state.Failures > 0 {
+		state.Failures--
+		if state.LastFailureAt.Equal(reservedAt) {
+			state.LastFailureAt = previousAt
+		}
+		m.states[key] = state
+	}
+	return nil
+}
+
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, key)
+	return nil
+}
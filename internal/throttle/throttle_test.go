@@ -0,0 +1,203 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         8 * time.Second,
+	LockoutThreshold: 10,
+	LockoutDuration:  15 * time.Minute,
+	Window:           time.Hour,
+}
+
+func allowAll(State) bool { return true }
+
+func TestPolicy_RetryAfter(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{9, 8 * time.Second},
+		{10, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		got := testPolicy.RetryAfter(State{Failures: tt.failures, LastFailureAt: now}, now)
+		if got != tt.want {
+			t.Errorf("With %d failures expected %v, got %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestPolicy_RetryAfterElapses(t *testing.T) {
+	last := time.Now()
+	state := State{Failures: 10, LastFailureAt: last}
+
+	if got := testPolicy.RetryAfter(state, last.Add(10*time.Minute)); got != 5*time.Minute {
+		t.Errorf("Expected 5m of lockout left, got %v", got)
+	}
+	if got := testPolicy.RetryAfter(state, last.Add(16*time.Minute)); got != 0 {
+		t.Errorf("Expected lockout to be over, got %v", got)
+	}
+}
+
+func TestLimiter_ReserveAndSucceed(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewLimiter(NewMemoryStore(), testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		_, wait, err := limiter.Reserve(ctx, "account:a@example.com")
+		if err != nil {
+			t.Fatalf("Reserve failed: %v", err)
+		}
+		if wait != 0 {
+			t.Errorf("Expected attempt %d to go ahead, got a wait of %v", i+1, wait)
+		}
+	}
+	if _, wait, _ := limiter.Reserve(ctx, "account:a@example.com"); wait != time.Second {
+		t.Errorf("Expected 1s backoff, got %v", wait)
+	}
+	if _, wait, _ := limiter.Reserve(ctx, "account:b@example.com"); wait != 0 {
+		t.Errorf("Other keys should not be affected, got %v", wait)
+	}
+
+	if err := limiter.Succeed(ctx, "account:a@example.com"); err != nil {
+		t.Fatalf("Succeed failed: %v", err)
+	}
+	if _, wait, _ := limiter.Reserve(ctx, "account:a@example.com"); wait != 0 {
+		t.Errorf("Expected no wait after success, got %v", wait)
+	}
+}
+
+func TestLimiter_ReserveAndRelease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	limiter := NewLimiter(store, testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		if _, wait, err := limiter.Reserve(ctx, "k"); err != nil || wait != 0 {
+			t.Fatalf("Expected attempt %d to go ahead, got a wait of %v: %v", i+1, wait, err)
+		}
+	}
+	refused, wait, _ := limiter.Reserve(ctx, "k")
+	if wait != time.Second {
+		t.Errorf("Expected 1s backoff, got %v", wait)
+	}
+	if err := limiter.Release(ctx, refused); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if got := store.states["k"].Failures; got != 4 {
+		t.Errorf("Releasing a refused attempt should change nothing, got %d failures", got)
+	}
+
+	// An attempt made later and released leaves the key as it was, wait
+	// included.
+	limiter.now = func() time.Time { return now.Add(time.Second) }
+	r, wait, _ := limiter.Reserve(ctx, "k")
+	if wait != 0 {
+		t.Fatalf("Expected the attempt to go ahead after the backoff, got %v", wait)
+	}
+	if err := limiter.Release(ctx, r); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	state := store.states["k"]
+	if state.Failures != 4 || !state.LastFailureAt.Equal(now) {
+		t.Errorf("Expected 4 failures at %v after release, got %+v", now, state)
+	}
+}
+
+func TestLimiter_ReserveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewLimiter(NewMemoryStore(), testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, wait, _ := limiter.Reserve(ctx, "k"); wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != testPolicy.FreeAttempts+1 {
+		t.Errorf("Expected %d attempts to go ahead, got %d", testPolicy.FreeAttempts+1, allowed)
+	}
+}
+
+func TestMemoryStore_WindowResetsCount(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	store.Reserve(ctx, "k", now, time.Minute, allowAll)
+	state, _, _ := store.Reserve(ctx, "k", now.Add(30*time.Second), time.Minute, allowAll)
+	if state.Failures != 2 {
+		t.Errorf("Expected 2 failures, got %d", state.Failures)
+	}
+	state, _, _ = store.Reserve(ctx, "k", now.Add(2*time.Minute), time.Minute, allowAll)
+	if state.Failures != 1 {
+		t.Errorf("Expected count to restart after the window, got %d", state.Failures)
+	}
+}
+
+func TestMemoryStore_ReleaseKeepsLaterFailure(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	store.Reserve(ctx, "k", now, time.Hour, allowAll)
+	store.Reserve(ctx, "k", now.Add(time.Second), time.Hour, allowAll)
+	store.Reserve(ctx, "k", now.Add(2*time.Second), time.Hour, allowAll)
+	// The attempt at now+1s is taken back after a later one was counted.
+	store.Release(ctx, "k", now.Add(time.Second), now)
+
+	state := store.states["k"]
+	if state.Failures != 2 || !state.LastFailureAt.Equal(now.Add(2*time.Second)) {
+		t.Errorf("Expected 2 failures with the later failure time kept, got %+v", state)
+	}
+}
+
+func TestMemoryStore_ConcurrentFailures(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Reserve(ctx, "k", now, time.Hour, allowAll)
+		}()
+	}
+	wg.Wait()
+
+	if state := store.states["k"]; state.Failures != 100 {
+		t.Errorf("Expected 100 failures, got %d", state.Failures)
+	}
+}
@@ -0,0 +1,118 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE key parameters and algorithms (RFC 9053) this package supports.
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+	AlgorithmRS256 = -257
+)
+
+// minRSAKeyBits is the smallest RSA key accepted for a credential.
+const minRSAKeyBits = 2048
+
+// ErrInvalidSignature is returned when an assertion is not signed by the
+// credential's key.
+var ErrInvalidSignature = errors.New("webauthn: invalid signature")
+
+// publicKey is a decoded COSE credential key.
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key as authenticators encode it in the
+// attested credential data.
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	item, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing data after credential key")
+	}
+	m, ok := item.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: credential key is not a map")
+	}
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlgorithm)].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgorithmES256:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: invalid P-256 credential key")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: invalid P-256 credential key: %w", err)
+		}
+		return &publicKey{algorithm: alg, key: key}, nil
+	case kty == coseKeyTypeOKP && alg == AlgorithmEdDSA:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("webauthn: invalid Ed25519 credential key")
+		}
+		return &publicKey{algorithm: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == AlgorithmRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n)*8 < minRSAKeyBits || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: invalid RSA credential key")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return &publicKey{algorithm: alg, key: key}, nil
+	}
+	return nil, fmt.Errorf("webauthn: unsupported credential key type %d with algorithm %d", kty, alg)
+}
+
+// verify checks sig over signed with the algorithm the key was registered
+// for.
+func (k *publicKey) verify(signed, sig []byte) error {
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		ok = ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, signed, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
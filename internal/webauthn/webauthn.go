@@ -0,0 +1,375 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies used for passkey logins.
+//
+// Attestation statements are not verified: Chirpy asks for no attestation
+// and trusts a credential because a logged in user registered it, not
+// because of the authenticator model that made it.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChallengeTimeout is how long the user has to finish a ceremony. Browsers get
+// it as the timeout of the options.
+const ChallengeTimeout = 5 * time.Minute
+
+// maxCredentialIDLength is the limit the specification puts on credential IDs.
+const maxCredentialIDLength = 1023
+
+// Authenticator data flags.
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// ErrSignCountRegressed is returned when an authenticator reports a signature
+// counter that did not grow, a sign that the credential was cloned.
+var ErrSignCountRegressed = errors.New("webauthn: signature counter did not increase")
+
+// Config describes the relying party.
+type Config struct {
+	// RPID is the domain credentials are scoped to, e.g. "chirpy.example".
+	RPID   string
+	RPName string
+	// Origin is the origin of the pages that run the ceremonies, e.g.
+	// "https://chirpy.example".
+	Origin string
+}
+
+// RelyingParty runs ceremonies for one configuration.
+type RelyingParty struct {
+	config Config
+}
+
+func New(config Config) (*RelyingParty, error) {
+	if config.RPID == "" || config.Origin == "" {
+		return nil, fmt.Errorf("webauthn needs a relying party id and an origin")
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+	return &RelyingParty{config: config}, nil
+}
+
+// URLEncodedBase64 is binary data that is base64url encoded in JSON, as the
+// WebAuthn JSON serialization of credentials and options expects.
+type URLEncodedBase64 []byte
+
+func (b URLEncodedBase64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLEncodedBase64) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*b = nil
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// NewChallenge returns a random challenge for a ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// User is the account a credential is registered for. ID is the user handle
+// the authenticator stores, it must not contain personal information.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded credential key.
+	PublicKey []byte
+	SignCount uint32
+}
+
+type credentialDescriptor struct {
+	Type string           `json:"type"`
+	ID   URLEncodedBase64 `json:"id"`
+}
+
+func descriptors(ids [][]byte) []credentialDescriptor {
+	out := make([]credentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, credentialDescriptor{Type: "public-key", ID: id})
+	}
+	return out
+}
+
+// CreationOptions are the options for navigator.credentials.create().
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          URLEncodedBase64 `json:"id"`
+		Name        string           `json:"name"`
+		DisplayName string           `json:"displayName"`
+	} `json:"user"`
+	Challenge        URLEncodedBase64 `json:"challenge"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// CreationOptions returns the options to register a passkey for user.
+// exclude lists the user's existing credentials, so an authenticator is not
+// registered twice.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude [][]byte) CreationOptions {
+	var o CreationOptions
+	o.RP.ID = rp.config.RPID
+	o.RP.Name = rp.config.RPName
+	o.User.ID = user.ID
+	o.User.Name = user.Name
+	o.User.DisplayName = user.DisplayName
+	o.Challenge = challenge
+	for _, alg := range []int{AlgorithmES256, AlgorithmEdDSA, AlgorithmRS256} {
+		o.PubKeyCredParams = append(o.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	o.Timeout = ChallengeTimeout.Milliseconds()
+	o.ExcludeCredentials = descriptors(exclude)
+	// Passkeys replace the password, so they have to be discoverable and
+	// verify the user themselves.
+	o.AuthenticatorSelection.ResidentKey = "required"
+	o.AuthenticatorSelection.UserVerification = "required"
+	o.Attestation = "none"
+	return o
+}
+
+// RequestOptions are the options for navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        URLEncodedBase64       `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RequestOptions returns the options to log in. An empty allow list lets the
+// user pick any of their passkeys for this site.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          ChallengeTimeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// ClientData is the data the browser signs over, collectedClientData in the
+// specification.
+type ClientData struct {
+	Type        string           `json:"type"`
+	Challenge   URLEncodedBase64 `json:"challenge"`
+	Origin      string           `json:"origin"`
+	CrossOrigin bool             `json:"crossOrigin"`
+}
+
+// ParseClientData decodes the client data of a response. Servers use the
+// challenge in it to find the ceremony the response belongs to.
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	var clientData ClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	return &clientData, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("webauthn: client data is for %q, not %q", clientData.Type, ceremony)
+	}
+	if subtle.ConstantTimeCompare(clientData.Challenge, challenge) != 1 {
+		return errors.New("webauthn: challenge does not match")
+	}
+	if clientData.Origin != rp.config.Origin || clientData.CrossOrigin {
+		return fmt.Errorf("webauthn: unexpected origin %q", clientData.Origin)
+	}
+	return nil
+}
+
+// authenticatorData is the parsed authenticator data of a response.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data is too short")
+	}
+	a := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if a.flags&flagAttestedCredData == 0 {
+		return a, nil
+	}
+	rest := data[37:]
+	// The AAGUID identifies the authenticator model, which is of no interest
+	// without attestation.
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: attested credential data is too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength > maxCredentialIDLength || len(rest) < idLength {
+		return nil, errors.New("webauthn: invalid credential id")
+	}
+	a.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid credential key: %w", err)
+	}
+	a.publicKey = rest[:len(rest)-len(extensions)]
+	return a, nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(a *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.config.RPID))
+	if !bytes.Equal(a.rpIDHash, rpIDHash[:]) {
+		return errors.New("webauthn: credential is for another relying party")
+	}
+	if a.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user was not present")
+	}
+	if a.flags&flagUserVerified == 0 {
+		return errors.New("webauthn: user was not verified")
+	}
+	return nil
+}
+
+// AttestationResponse is the response of navigator.credentials.create().
+type AttestationResponse struct {
+	ClientDataJSON    URLEncodedBase64 `json:"clientDataJSON"`
+	AttestationObject URLEncodedBase64 `json:"attestationObject"`
+}
+
+// VerifyRegistration checks the response of a registration ceremony started
+// with challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, response AttestationResponse) (*Credential, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	item, _, err := decodeCBOR(response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid attestation object: %w", err)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, errors.New("webauthn: response has no credential")
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:        bytes.Clone(authData.credentialID),
+		PublicKey: bytes.Clone(authData.publicKey),
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// AssertionResponse is the response of navigator.credentials.get().
+type AssertionResponse struct {
+	ClientDataJSON    URLEncodedBase64 `json:"clientDataJSON"`
+	AuthenticatorData URLEncodedBase64 `json:"authenticatorData"`
+	Signature         URLEncodedBase64 `json:"signature"`
+	UserHandle        URLEncodedBase64 `json:"userHandle"`
+}
+
+// VerifyAssertion checks the response of an authentication ceremony started
+// with challenge against the stored credential. It returns the signature
+// counter to store for the next login.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential Credential, response AssertionResponse) (uint32, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := parseAuthenticatorData(response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(response.ClientDataJSON)
+	signed := append(bytes.Clone(response.AuthenticatorData), clientDataHash[:]...)
+	if err := key.verify(signed, response.Signature); err != nil {
+		return 0, err
+	}
+	// Authenticators that do not count always report zero, synced passkeys
+	// among them.
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+	return authData.signCount, nil
+}
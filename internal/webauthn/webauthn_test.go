@@ -0,0 +1,396 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR encodes the values the software authenticator needs. Map keys
+// are sorted like the canonical encoding authenticators use.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		var entries [][2][]byte
+		for key, value := range v {
+			entries = append(entries, [2][]byte{encodeCBOR(key), encodeCBOR(value)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i][0], entries[j][0]
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return string(a) < string(b)
+		})
+		out := head(5, uint64(len(v)))
+		for _, e := range entries {
+			out = append(append(out, e[0]...), e[1]...)
+		}
+		return out
+	}
+	panic("unsupported value")
+}
+
+// softAuthenticator is a passkey authenticator that keeps its key in memory.
+type softAuthenticator struct {
+	t            *testing.T
+	rpID         string
+	origin       string
+	credentialID []byte
+	userHandle   []byte
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey
+	signCount    uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{
+		t:            t,
+		rpID:         rpID,
+		origin:       origin,
+		credentialID: credentialID,
+		userHandle:   []byte("user-1"),
+		ecKey:        key,
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.edKey != nil {
+		return encodeCBOR(map[any]any{
+			coseKeyType:   coseKeyTypeOKP,
+			coseAlgorithm: AlgorithmEdDSA,
+			coseCurve:     coseCurveEd25519,
+			coseX:         []byte(a.edKey.Public().(ed25519.PublicKey)),
+		})
+	}
+	point, err := a.ecKey.PublicKey.Bytes()
+	if err != nil {
+		a.t.Fatalf("Setup failed: %v", err)
+	}
+	return encodeCBOR(map[any]any{
+		coseKeyType:   coseKeyTypeEC2,
+		coseAlgorithm: AlgorithmES256,
+		coseCurve:     coseCurveP256,
+		coseX:         point[1:33],
+		coseY:         point[33:],
+	})
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		a.t.Fatalf("Setup failed: %v", err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) create(challenge []byte) AttestationResponse {
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey()...)
+	return AttestationResponse{
+		ClientDataJSON: a.clientData("webauthn.create", challenge),
+		AttestationObject: encodeCBOR(map[any]any{
+			"fmt":      "none",
+			"attStmt":  map[any]any{},
+			"authData": a.authData(a.flags|flagAttestedCredData, attested),
+		}),
+	}
+}
+
+func (a *softAuthenticator) get(challenge []byte) AssertionResponse {
+	a.signCount++
+	authData := a.authData(a.flags, nil)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(authData, clientDataHash[:]...)
+	var sig []byte
+	if a.edKey != nil {
+		sig = ed25519.Sign(a.edKey, signed)
+	} else {
+		digest := sha256.Sum256(signed)
+		var err error
+		sig, err = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+		if err != nil {
+			a.t.Fatalf("Setup failed: %v", err)
+		}
+	}
+	return AssertionResponse{
+		ClientDataJSON:    clientData,
+		AuthenticatorData: authData,
+		Signature:         sig,
+		UserHandle:        a.userHandle,
+	}
+}
+
+func newTestRelyingParty(t *testing.T) *RelyingParty {
+	t.Helper()
+	rp, err := New(Config{RPID: "chirpy.test", Origin: "https://chirpy.test"})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	return rp
+}
+
+func register(t *testing.T, rp *RelyingParty, a *softAuthenticator) *Credential {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	credential, err := rp.VerifyRegistration(challenge, a.create(challenge))
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	return credential
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	for _, name := range []string{"ES256", "EdDSA"} {
+		t.Run(name, func(t *testing.T) {
+			a := newSoftAuthenticator(t, "chirpy.test", "https://chirpy.test")
+			if name == "EdDSA" {
+				_, a.edKey, _ = ed25519.GenerateKey(rand.Reader)
+			}
+			credential := register(t, rp, a)
+			if string(credential.ID) != string(a.credentialID) {
+				t.Errorf("Credential ID = %x, want %x", credential.ID, a.credentialID)
+			}
+
+			for range 2 {
+				challenge, _ := NewChallenge()
+				signCount, err := rp.VerifyAssertion(challenge, *credential, a.get(challenge))
+				if err != nil {
+					t.Fatalf("VerifyAssertion failed: %v", err)
+				}
+				if signCount != a.signCount {
+					t.Errorf("Sign count = %d, want %d", signCount, a.signCount)
+				}
+				credential.SignCount = signCount
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	challenge, _ := NewChallenge()
+	otherChallenge, _ := NewChallenge()
+
+	tests := []struct {
+		name  string
+		setup func(a *softAuthenticator) AttestationResponse
+	}{
+		{
+			name: "Wrong challenge",
+			setup: func(a *softAuthenticator) AttestationResponse {
+				return a.create(otherChallenge)
+			},
+		},
+		{
+			name: "Wrong origin",
+			setup: func(a *softAuthenticator) AttestationResponse {
+				a.origin = "https://evil.test"
+				return a.create(challenge)
+			},
+		},
+		{
+			name: "Wrong relying party",
+			setup: func(a *softAuthenticator) AttestationResponse {
+				a.rpID = "evil.test"
+				return a.create(challenge)
+			},
+		},
+		{
+			name: "User not verified",
+			setup: func(a *softAuthenticator) AttestationResponse {
+				a.flags = flagUserPresent
+				return a.create(challenge)
+			},
+		},
+		{
+			name: "Assertion instead of attestation",
+			setup: func(a *softAuthenticator) AttestationResponse {
+				response := a.create(challenge)
+				response.ClientDataJSON = a.clientData("webauthn.get", challenge)
+				return response
+			},
+		},
+		{
+			name: "Truncated attestation object",
+			setup: func(a *softAuthenticator) AttestationResponse {
+				response := a.create(challenge)
+				response.AttestationObject = response.AttestationObject[:len(response.AttestationObject)/2]
+				return response
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t, "chirpy.test", "https://chirpy.test")
+			if _, err := rp.VerifyRegistration(challenge, tt.setup(a)); err == nil {
+				t.Errorf("VerifyRegistration() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	challenge, _ := NewChallenge()
+	otherChallenge, _ := NewChallenge()
+
+	tests := []struct {
+		name    string
+		setup   func(a *softAuthenticator, credential *Credential) AssertionResponse
+		wantErr error
+	}{
+		{
+			name: "Wrong challenge",
+			setup: func(a *softAuthenticator, credential *Credential) AssertionResponse {
+				return a.get(otherChallenge)
+			},
+		},
+		{
+			name: "Signed by another key",
+			setup: func(a *softAuthenticator, credential *Credential) AssertionResponse {
+				a.ecKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				return a.get(challenge)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "Tampered authenticator data",
+			setup: func(a *softAuthenticator, credential *Credential) AssertionResponse {
+				response := a.get(challenge)
+				response.AuthenticatorData[36]++
+				return response
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "Sign count regressed",
+			setup: func(a *softAuthenticator, credential *Credential) AssertionResponse {
+				credential.SignCount = 10
+				return a.get(challenge)
+			},
+			wantErr: ErrSignCountRegressed,
+		},
+		{
+			name: "User not present",
+			setup: func(a *softAuthenticator, credential *Credential) AssertionResponse {
+				a.flags = flagUserVerified
+				return a.get(challenge)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t, "chirpy.test", "https://chirpy.test")
+			credential := register(t, rp, a)
+			_, err := rp.VerifyAssertion(challenge, *credential, tt.setup(a, credential))
+			if err == nil {
+				t.Fatalf("VerifyAssertion() succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyAssertion() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionWithoutCounter(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	a := newSoftAuthenticator(t, "chirpy.test", "https://chirpy.test")
+	credential := register(t, rp, a)
+	// Synced passkeys always report zero.
+	for range 2 {
+		challenge, _ := NewChallenge()
+		a.signCount = 0
+		response := a.get(challenge)
+		binary.BigEndian.PutUint32(response.AuthenticatorData[33:37], 0)
+		clientDataHash := sha256.Sum256(response.ClientDataJSON)
+		digest := sha256.Sum256(append(response.AuthenticatorData, clientDataHash[:]...))
+		response.Signature, _ = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+		if _, err := rp.VerifyAssertion(challenge, *credential, response); err != nil {
+			t.Fatalf("VerifyAssertion failed: %v", err)
+		}
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	item, rest, err := decodeCBOR(append(encodeCBOR(map[any]any{1: -7, "a": []byte{1, 2}}), 0xff))
+	if err != nil {
+		t.Fatalf("decodeCBOR failed: %v", err)
+	}
+	m := item.(map[any]any)
+	if m[int64(1)] != int64(-7) || string(m["a"].([]byte)) != "\x01\x02" {
+		t.Errorf("decodeCBOR() = %v", m)
+	}
+	if len(rest) != 1 {
+		t.Errorf("Rest = %x, want ff", rest)
+	}
+
+	for _, bad := range [][]byte{
+		{},
+		{0x5a, 0xff, 0xff, 0xff, 0xff},
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0xa2, 0x01, 0x01, 0x01, 0x01},
+		{0x5f},
+	} {
+		if _, _, err := decodeCBOR(bad); err == nil {
+			t.Errorf("decodeCBOR(%x) succeeded, want error", bad)
+		}
+	}
+}
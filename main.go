@@ -1,23 +1,46 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"sync/atomic"
+	"time"
 
+	"github.com/Pepegakac123/chirpy/internal/auth"
 	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/Pepegakac123/chirpy/internal/mail"
+	"github.com/Pepegakac123/chirpy/internal/oidc"
+	"github.com/Pepegakac123/chirpy/internal/throttle"
+	"github.com/Pepegakac123/chirpy/internal/webauthn"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	fileServerHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	token          string
-	polkaApiKey    string
+	fileServerHits       atomic.Int32
+	db                   *database.Queries
+	sqlDB                *sql.DB
+	platform             string
+	keys                 *auth.KeyRing
+	polkaApiKey          string
+	tokenPepper          string
+	trustedProxies       int
+	mailer               mail.Mailer
+	baseURL              string
+	requireVerifiedEmail bool
+	accountThrottle      *throttle.Limiter
+	ipThrottle           *throttle.Limiter
+	oidcProviders        map[string]*oidc.Provider
+	passwordPolicy       auth.PasswordPolicy
+	accountDeletionGrace time.Duration
+	webauthn             *webauthn.RelyingParty
+	chirpEditWindow      time.Duration
 }
 
 func main() {
@@ -29,8 +52,115 @@ func main() {
 		return
 	}
 	dbQueries := database.New(db)
-	apiCfg := apiConfig{fileServerHits: atomic.Int32{}, db: dbQueries, platform: os.Getenv("PLATFORM"), token: os.Getenv("TOKEN"), polkaApiKey: os.Getenv("POLKA_KEY")}
+	if adminEmail := os.Getenv("ADMIN_EMAIL"); adminEmail != "" {
+		if err := promoteAdmin(context.Background(), dbQueries, adminEmail); err != nil {
+			fmt.Printf("Failed to promote admin: %v\n", err)
+		}
+	}
+	keys, err := loadKeyRing(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = loadLegacySecret(keys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// The pepper keys every opaque secret we store a hash of, refresh tokens
+	// were simply the first of them.
+	tokenPepper := os.Getenv("REFRESH_TOKEN_PEPPER")
+	if tokenPepper == "" {
+		fmt.Printf("REFRESH_TOKEN_PEPPER is not set, tokens are hashed without a pepper\n")
+	}
+	mailer, err := loadMailer()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = loadPasswordParams()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	accountThrottle, ipThrottle, err := loadLoginThrottles(db)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	graceDays, err := envInt("ACCOUNT_DELETION_GRACE_DAYS", 30)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if graceDays < 0 {
+		fmt.Println("ACCOUNT_DELETION_GRACE_DAYS must not be negative")
+		return
+	}
 	const port string = "8080"
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	oidcProviders, err := loadOIDCProviders(os.Getenv("OIDC_PROVIDERS_FILE"), baseURL)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	relyingParty, err := loadRelyingParty(baseURL)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	editWindowMinutes, err := envInt("CHIRP_EDIT_WINDOW_MINUTES", 15)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if editWindowMinutes < 0 {
+		fmt.Println("CHIRP_EDIT_WINDOW_MINUTES must not be negative")
+		return
+	}
+	// Each trusted proxy in front of the server appends the address it got
+	// the request from to X-Forwarded-For.
+	trustedProxies := 0
+	if os.Getenv("TRUST_PROXY") == "true" {
+		trustedProxies, err = envInt("TRUSTED_PROXY_HOPS", 1)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if trustedProxies < 1 {
+			fmt.Println("TRUSTED_PROXY_HOPS must be at least 1")
+			return
+		}
+	}
+	apiCfg := apiConfig{
+		fileServerHits:       atomic.Int32{},
+		db:                   dbQueries,
+		sqlDB:                db,
+		platform:             os.Getenv("PLATFORM"),
+		keys:                 keys,
+		polkaApiKey:          os.Getenv("POLKA_KEY"),
+		tokenPepper:          tokenPepper,
+		trustedProxies:       trustedProxies,
+		mailer:               mailer,
+		baseURL:              baseURL,
+		requireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		accountThrottle:      accountThrottle,
+		ipThrottle:           ipThrottle,
+		oidcProviders:        oidcProviders,
+		passwordPolicy:       passwordPolicy,
+		accountDeletionGrace: time.Duration(graceDays) * 24 * time.Hour,
+		webauthn:             relyingParty,
+		chirpEditWindow:      time.Duration(editWindowMinutes) * time.Minute,
+	}
+	go apiCfg.purgeDeletedAccounts(context.Background(), time.Hour)
 	mux := http.NewServeMux()
 	handleRouting(mux, &apiCfg)
 	srv := &http.Server{
@@ -48,18 +178,64 @@ func main() {
 func handleRouting(mux *http.ServeMux, apiCfg *apiConfig) {
 	mux.Handle("/app/", http.StripPrefix("/app", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))))
 	mux.HandleFunc("GET /api/healthz", checkHealth)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("GET /api/auth/{provider}/login", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", apiCfg.handlerOIDCCallback)
+	mux.HandleFunc("POST /api/login/passkey/begin", apiCfg.handlerBeginPasskeyLogin)
+	mux.HandleFunc("POST /api/login/passkey/finish", apiCfg.handlerFinishPasskeyLogin)
+	mux.HandleFunc("POST /api/passkeys/register/begin", apiCfg.RequireSession(apiCfg.handlerBeginPasskeyRegistration))
+	mux.HandleFunc("POST /api/passkeys/register/finish", apiCfg.RequireSession(apiCfg.handlerFinishPasskeyRegistration))
+	mux.HandleFunc("GET /api/passkeys", apiCfg.RequireSession(apiCfg.handlerListPasskeys))
+	mux.HandleFunc("DELETE /api/passkeys/{passkeyID}", apiCfg.RequireSession(apiCfg.handlerDeletePasskey))
+	mux.HandleFunc("POST /api/mfa/totp/enroll", apiCfg.RequireSession(apiCfg.handlerEnrollTOTP))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.RequireSession(apiCfg.handlerConfirmTOTP))
+	mux.HandleFunc("POST /api/mfa/totp/disable", apiCfg.RequireSession(apiCfg.handlerDisableTOTP))
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", apiCfg.RequireSession(apiCfg.handlerListSessions))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.RequireSession(apiCfg.handlerRevokeSession))
+	mux.HandleFunc("POST /api/sessions/revoke-all", apiCfg.RequireSession(apiCfg.handlerRevokeAllSessions))
+	mux.HandleFunc("POST /api/tokens", apiCfg.RequireSession(apiCfg.handlerCreatePersonalAccessToken))
+	mux.HandleFunc("GET /api/tokens", apiCfg.RequireSession(apiCfg.handlerListPersonalAccessTokens))
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.RequireSession(apiCfg.handlerRevokePersonalAccessToken))
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.RequireSession(apiCfg.handlerCreateOAuthClient))
+	mux.HandleFunc("GET /api/oauth/clients", apiCfg.RequireSession(apiCfg.handlerListOAuthClients))
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.RequireSession(apiCfg.handlerDeleteOAuthClient))
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerAuthorizePage)
+	mux.HandleFunc("GET /api/oauth/authorize", apiCfg.RequireSession(apiCfg.handlerAuthorize))
+	mux.HandleFunc("POST /api/oauth/authorize", apiCfg.RequireSession(apiCfg.handlerAuthorizeDecision))
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+	mux.HandleFunc("POST /api/password-reset/request", apiCfg.handlerRequestPasswordReset)
+	mux.HandleFunc("POST /api/password-reset/confirm", apiCfg.handlerConfirmPasswordReset)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsers)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUsers)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirps)
+	mux.HandleFunc("PUT /api/users", apiCfg.RequireAuth(auth.ScopeProfileWrite, apiCfg.handlerUpdateUsers))
+	mux.HandleFunc("PATCH /api/users", apiCfg.RequireAuth(auth.ScopeProfileWrite, apiCfg.handlerUpdateUsers))
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.RequireSession(apiCfg.handlerDeleteAccount))
+	mux.HandleFunc("GET /api/users/me/export", apiCfg.RequireSession(apiCfg.handlerExportAccount))
+	mux.HandleFunc("POST /api/users/verify-email", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify-email/resend", apiCfg.RequireSession(apiCfg.handlerResendEmailVerification))
+	mux.HandleFunc("POST /api/chirps", apiCfg.RequireAuth(auth.ScopeChirpsWrite, apiCfg.handlerCreateChirps))
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetSingleChirp)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteSingleChirp)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.HandleFunc("GET /api/chirps", apiCfg.OptionalAuth(apiCfg.handlerGetChirps))
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.OptionalAuth(apiCfg.handlerSearchChirps))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.OptionalAuth(apiCfg.handlerGetSingleChirp))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.RequireAuth(auth.ScopeChirpsWrite, apiCfg.handlerUpdateChirp))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerListChirpRevisions)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.OptionalAuth(apiCfg.handlerGetThread))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.RequireAuth(auth.ScopeChirpsWrite, apiCfg.handlerLikeChirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.RequireAuth(auth.ScopeChirpsWrite, apiCfg.handlerUnlikeChirp))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/likes", apiCfg.handlerListChirpLikes)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.RequireAuth(auth.ScopeChirpsWrite, apiCfg.handlerDeleteSingleChirp))
+	mux.HandleFunc("GET /admin/metrics", apiCfg.RequirePermission(auth.PermissionViewMetrics, apiCfg.handlerMetrics))
+	mux.HandleFunc("POST /admin/reset", apiCfg.RequirePermission(auth.PermissionResetData, apiCfg.handlerReset))
+	mux.HandleFunc("GET /admin/password-hashes", apiCfg.RequirePermission(auth.PermissionViewMetrics, apiCfg.handlerPasswordHashReport))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.RequirePermission(auth.PermissionManageUsers, apiCfg.handlerSetUserRole))
+	mux.HandleFunc("POST /admin/users/{userID}/impersonate", apiCfg.RequirePermission(auth.PermissionImpersonate, apiCfg.handlerImpersonateUser))
+	mux.HandleFunc("GET /admin/audit-log", apiCfg.RequirePermission(auth.PermissionManageUsers, apiCfg.handlerListAuditLog))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -68,3 +244,247 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// loadKeyRing reads the JWT signing keys from dir. Without a directory an
+// ephemeral key is generated, which means tokens do not survive a restart.
+func loadKeyRing(dir, activeKid string) (*auth.KeyRing, error) {
+	if dir != "" {
+		return auth.LoadKeyRing(dir, activeKid)
+	}
+	fmt.Printf("JWT_KEYS_DIR is not set, using an ephemeral signing key\n")
+	key, err := auth.GenerateEd25519Key()
+	if err != nil {
+		return nil, err
+	}
+	ring := auth.NewKeyRing()
+	if err := ring.AddKey("ephemeral", key); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// loadMailer picks the mail transport from MAIL_DRIVER: "smtp", "file" or
+// "log", which prints messages to stdout and is the default.
+func loadMailer() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@chirpy.local>"
+	}
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return mail.NewSMTPMailer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "mail.log"
+		}
+		return mail.NewFileMailer(path, from)
+	case "", "log":
+		return mail.NewLogMailer(os.Stdout, from), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// loadLoginThrottles builds the limiters for failed logins per account and
+// per client IP. The IP limiter tolerates more failures since many users can
+// share one address.
+func loadLoginThrottles(db *sql.DB) (*throttle.Limiter, *throttle.Limiter, error) {
+	var store throttle.Store
+	switch kind := os.Getenv("LOGIN_THROTTLE_STORE"); kind {
+	case "", "postgres":
+		store = throttle.NewPostgresStore(db)
+	case "memory":
+		store = throttle.NewMemoryStore()
+	default:
+		return nil, nil, fmt.Errorf("unknown LOGIN_THROTTLE_STORE %q", kind)
+	}
+	lockoutDuration, err := envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	if err != nil {
+		return nil, nil, err
+	}
+	accountThreshold, err := envInt("LOGIN_LOCKOUT_THRESHOLD", 10)
+	if err != nil {
+		return nil, nil, err
+	}
+	ipThreshold, err := envInt("LOGIN_IP_LOCKOUT_THRESHOLD", 100)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy := throttle.Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: accountThreshold,
+		LockoutDuration:  lockoutDuration,
+		Window:           24 * time.Hour,
+	}
+	ipPolicy := policy
+	ipPolicy.FreeAttempts = ipThreshold / 4
+	ipPolicy.LockoutThreshold = ipThreshold
+	return throttle.NewLimiter(store, policy), throttle.NewLimiter(store, ipPolicy), nil
+}
+
+// loadOIDCProviders reads the external login providers from a JSON file
+// holding an array of oidc.Config. Without a file there are none.
+func loadOIDCProviders(path, baseURL string) (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+	if path == "" {
+		return providers, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []oidc.Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, config := range configs {
+		if config.RedirectURL == "" {
+			config.RedirectURL = baseURL + "/api/auth/" + config.Name + "/callback"
+		}
+		provider, err := oidc.NewProvider(config, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, exists := providers[config.Name]; exists {
+			return nil, fmt.Errorf("%s: provider %q is configured twice", path, config.Name)
+		}
+		providers[config.Name] = provider
+	}
+	return providers, nil
+}
+
+// loadRelyingParty configures passkeys. By default credentials are scoped to
+// the host of BASE_URL and the ceremonies run on its origin.
+func loadRelyingParty(baseURL string) (*webauthn.RelyingParty, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("BASE_URL: %w", err)
+	}
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = u.Hostname()
+	}
+	origin := os.Getenv("WEBAUTHN_ORIGIN")
+	if origin == "" {
+		origin = u.Scheme + "://" + u.Host
+	}
+	return webauthn.New(webauthn.Config{RPID: rpID, RPName: "Chirpy", Origin: origin})
+}
+
+// loadLegacySecret accepts tokens signed with the old shared TOKEN secret
+// that were issued before LEGACY_HS256_UNTIL (RFC 3339). Without it they are
+// rejected.
+func loadLegacySecret(keys *auth.KeyRing) error {
+	value := os.Getenv("LEGACY_HS256_UNTIL")
+	if value == "" {
+		return nil
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("invalid LEGACY_HS256_UNTIL: %w", err)
+	}
+	secret := os.Getenv("TOKEN")
+	if secret == "" {
+		return fmt.Errorf("LEGACY_HS256_UNTIL is set but TOKEN is not")
+	}
+	keys.SetLegacySecret(secret, until)
+	return nil
+}
+
+// loadPasswordParams reads the argon2id cost of new password hashes from
+// ARGON2_MEMORY (KiB), ARGON2_ITERATIONS and ARGON2_PARALLELISM. Raising them
+// upgrades existing hashes as their owners log in.
+func loadPasswordParams() error {
+	defaults := auth.DefaultPasswordParams()
+	memory, err := envInt("ARGON2_MEMORY", int(defaults.Memory))
+	if err != nil {
+		return err
+	}
+	iterations, err := envInt("ARGON2_ITERATIONS", int(defaults.Iterations))
+	if err != nil {
+		return err
+	}
+	parallelism, err := envInt("ARGON2_PARALLELISM", int(defaults.Parallelism))
+	if err != nil {
+		return err
+	}
+	if memory < 0 || iterations < 0 || parallelism < 0 || parallelism > 255 {
+		return fmt.Errorf("argon2id parameters out of range")
+	}
+	return auth.SetPasswordParams(auth.PasswordParams{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+	})
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// loadPasswordPolicy reads the rules for new passwords. The breached password
+// corpus is optional, without it only the length and email rules apply.
+func loadPasswordPolicy() (auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy()
+	minLength, err := envInt("PASSWORD_MIN_LENGTH", policy.MinLength)
+	if err != nil {
+		return policy, err
+	}
+	maxLength, err := envInt("PASSWORD_MAX_LENGTH", policy.MaxLength)
+	if err != nil {
+		return policy, err
+	}
+	if minLength < 1 || maxLength < minLength {
+		return policy, fmt.Errorf("password length limits out of range")
+	}
+	policy.MinLength = minLength
+	policy.MaxLength = maxLength
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		policy.Breached, err = auth.LoadBreachedPasswords(path)
+		if err != nil {
+			return policy, err
+		}
+		fmt.Printf("Loaded %d breached password hashes\n", policy.Breached.Len())
+	}
+	return policy, nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// withTx runs fn in a transaction, rolling it back when fn fails.
+func (c *apiConfig) withTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := c.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(c.db.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Pepegakac123/chirpy/internal/auth"
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Principal is the authenticated caller of a request. Handlers behind the
+// auth middleware read it from the request context instead of looking at
+// headers themselves.
+type Principal struct {
+	User database.User
+	// SessionID is the session of an access token, and uuid.Nil for
+	// personal access tokens.
+	SessionID uuid.UUID
+	// Scopes limit what a personal access token may do. Access tokens come
+	// from an interactive login and have no scopes, they may do anything.
+	Scopes []string
+	// PersonalAccessToken is set when the caller used a personal access
+	// token instead of an access token.
+	PersonalAccessToken bool
+	// ClientID is set when a third party OAuth client acts for the user.
+	ClientID string
+	// ActorID is the admin behind an impersonation token, and uuid.Nil
+	// when the user acts themselves.
+	ActorID uuid.UUID
+}
+
+// Impersonated reports whether an admin acts as the user.
+func (p *Principal) Impersonated() bool {
+	return p.ActorID != uuid.Nil
+}
+
+// Delegated reports whether the credential only grants the listed scopes,
+// which is the case for personal access tokens and OAuth clients.
+func (p *Principal) Delegated() bool {
+	return p.PersonalAccessToken || p.ClientID != ""
+}
+
+// HasScope reports whether the principal may act within scope.
+func (p *Principal) HasScope(scope string) bool {
+	return !p.Delegated() || auth.HasScope(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns the caller of the request, or nil when it was not
+// authenticated.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// RequireAuth lets through requests with a valid access token, or a personal
+// access token granted scope.
+func (c *apiConfig) RequireAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p, err := c.resolvePrincipal(req)
+		if err != nil {
+			respondWithPrincipalError(w, err)
+			return
+		}
+		if !p.HasScope(scope) {
+			respondWithAuthError(w, fmt.Errorf("%w: %s", auth.ErrInsufficientScope, scope))
+			return
+		}
+		next(w, req.WithContext(withPrincipal(req.Context(), p)))
+	}
+}
+
+// RequireSession is RequireAuth for actions that take an interactive login,
+// like managing sessions, tokens or second factors. Personal access tokens
+// and tokens of OAuth clients are refused, and so are admins impersonating
+// the user.
+func (c *apiConfig) RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p, err := c.resolvePrincipal(req)
+		if err != nil {
+			respondWithPrincipalError(w, err)
+			return
+		}
+		if p.Delegated() {
+			respondWithAuthError(w, auth.ErrInsufficientScope)
+			return
+		}
+		if p.Impersonated() {
+			respondWithImpersonationForbidden(w)
+			return
+		}
+		next(w, req.WithContext(withPrincipal(req.Context(), p)))
+	}
+}
+
+// OptionalAuth adds the principal to the context when the request carries
+// credentials and passes anonymous requests through unchanged. Credentials
+// that are present but invalid are still rejected.
+func (c *apiConfig) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p, err := c.resolvePrincipal(req)
+		if errors.Is(err, auth.ErrMissingAuthHeader) {
+			next(w, req)
+			return
+		}
+		if err != nil {
+			respondWithPrincipalError(w, err)
+			return
+		}
+		next(w, req.WithContext(withPrincipal(req.Context(), p)))
+	}
+}
+
+// RequirePermission only lets session requests through whose user currently
+// has a role with the given permission. The role is read from the user row,
+// not the token, so a demotion takes effect at once.
+func (c *apiConfig) RequirePermission(permission auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return c.RequireSession(func(w http.ResponseWriter, req *http.Request) {
+		if !auth.Role(principalFrom(req.Context()).User.Role).Can(permission) {
+			respondWithErrorCode(w, 403, "forbidden", "You do not have permission to do this")
+			return
+		}
+		next(w, req)
+	})
+}
+
+// resolvePrincipal authenticates the bearer credential of the request and
+// loads the user behind it. Errors are the ones of the auth package, or
+// errDatabase wrapped around a failed query.
+func (c *apiConfig) resolvePrincipal(req *http.Request) (*Principal, error) {
+	bearerToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return nil, err
+	}
+	var p *Principal
+	if auth.IsPersonalAccessToken(bearerToken) {
+		p, err = c.resolvePersonalAccessToken(req.Context(), bearerToken)
+	} else {
+		p, err = c.resolveAccessToken(bearerToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := c.tokenUser(req.Context(), p.User.ID, p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	p.User = user
+	if p.Impersonated() {
+		if err := c.auditImpersonatedRequest(req, p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// tokenUser loads the user a valid token was issued to and checks that the
+// token still counts: its session, unless it has none, was not revoked and
+// the account is not being deleted. Errors are like those of
+// resolvePrincipal.
+func (c *apiConfig) tokenUser(ctx context.Context, userID, sessionID uuid.UUID) (database.User, error) {
+	// Revoking a session revokes its refresh tokens, its access tokens stop
+	// working with them instead of at their expiry.
+	if sessionID != uuid.Nil {
+		active, err := c.db.SessionIsActive(ctx, sessionID)
+		if err != nil {
+			return database.User{}, fmt.Errorf("%w: %w", errDatabase, err)
+		}
+		if !active {
+			return database.User{}, fmt.Errorf("%w: session was revoked", auth.ErrTokenInvalid)
+		}
+	}
+	user, err := c.db.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.User{}, fmt.Errorf("%w: user no longer exists", auth.ErrTokenInvalid)
+		}
+		return database.User{}, fmt.Errorf("%w: %w", errDatabase, err)
+	}
+	// Logging in again cancels a scheduled deletion, until then the
+	// account's tokens do not work.
+	if user.DeleteAfter.Valid {
+		return database.User{}, fmt.Errorf("%w: account is scheduled for deletion", auth.ErrTokenInvalid)
+	}
+	return user, nil
+}
+
+// auditImpersonatedRequest checks that the admin behind an impersonation
+// token may still impersonate and records the request. Requests that can not
+// be recorded are refused.
+func (c *apiConfig) auditImpersonatedRequest(req *http.Request, p *Principal) error {
+	actor, err := c.db.GetUserByID(req.Context(), p.ActorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: impersonating admin no longer exists", auth.ErrTokenInvalid)
+		}
+		return fmt.Errorf("%w: %w", errDatabase, err)
+	}
+	if !auth.Role(actor.Role).Can(auth.PermissionImpersonate) {
+		return fmt.Errorf("%w: %s may no longer impersonate", auth.ErrTokenInvalid, actor.ID)
+	}
+	err = c.db.CreateAuditLogEntry(req.Context(), database.CreateAuditLogEntryParams{
+		ActorID: p.ActorID,
+		UserID:  p.User.ID,
+		Action:  auditImpersonatedRequest,
+		Detail:  req.Method + " " + req.URL.RequestURI(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", errDatabase, err)
+	}
+	return nil
+}
+
+func (c *apiConfig) resolveAccessToken(bearerToken string) (*Principal, error) {
+	claims, err := c.keys.ValidateClaims(bearerToken)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, err
+	}
+	actorID, err := claims.ActorID()
+	if err != nil {
+		return nil, err
+	}
+	// Tokens issued before sessions existed have no sid and stay uuid.Nil.
+	sessionID, _ := uuid.Parse(claims.SessionID)
+	if claims.ClientID != "" {
+		return &Principal{
+			User:      database.User{ID: userID},
+			SessionID: sessionID,
+			Scopes:    strings.Fields(claims.Scope),
+			ClientID:  claims.ClientID,
+		}, nil
+	}
+	return &Principal{
+		User:      database.User{ID: userID},
+		SessionID: sessionID,
+		ActorID:   actorID,
+	}, nil
+}
+
+// tokenTouchInterval is how precisely the last use of a personal access
+// token is recorded.
+const tokenTouchInterval = time.Minute
+
+func (c *apiConfig) resolvePersonalAccessToken(ctx context.Context, bearerToken string) (*Principal, error) {
+	token, err := c.db.GetPersonalAccessToken(ctx, c.hashToken(bearerToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("%w: %w", errDatabase, err)
+	}
+	if token.RevokedAt.Valid {
+		return nil, fmt.Errorf("%w: token was revoked", auth.ErrTokenInvalid)
+	}
+	if token.ExpiresAt.Valid && token.ExpiresAt.Time.Before(time.Now().UTC()) {
+		return nil, auth.ErrTokenExpired
+	}
+	// Public reads go through here as well, a write for every request would
+	// cost more than the request itself.
+	if !token.LastUsedAt.Valid || time.Since(token.LastUsedAt.Time) >= tokenTouchInterval {
+		if err := c.db.TouchPersonalAccessToken(ctx, token.ID); err != nil {
+			fmt.Printf("Failed to record token use: %v\n", err)
+		}
+	}
+	return &Principal{
+		User:                database.User{ID: token.UserID},
+		Scopes:              token.Scopes,
+		PersonalAccessToken: true,
+	}, nil
+}
+
+var errDatabase = errors.New("database error")
+
+func respondWithImpersonationForbidden(w http.ResponseWriter) {
+	respondWithErrorCode(w, 403, "impersonation_forbidden", "This action is not allowed while impersonating a user")
+}
+
+func respondWithPrincipalError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errDatabase) {
+		respondWithError(w, 500, "Database error")
+		return
+	}
+	respondWithAuthError(w, err)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is a position in a list ordered by (created_at, id), or by
+// (rank, id) for search results. Clients get it as an opaque token and must
+// not rely on its contents.
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+	Rank      float32
+}
+
+func (p pageCursor) String() string {
+	raw := p.CreatedAt.Format(time.RFC3339Nano) + "," + p.ID.String()
+	if p.Rank != 0 {
+		raw += "," + strconv.FormatFloat(float64(p.Rank), 'g', -1, 32)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parsePageCursor(token string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	parts := strings.Split(string(raw), ",")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, errInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	u, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	cursor := &pageCursor{CreatedAt: t, ID: u}
+	if len(parts) == 3 {
+		rank, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		cursor.Rank = float32(rank)
+	}
+	return cursor, nil
+}
+
+// pageRequest is the limit and cursor a client asked for. At most one of
+// after and before is set.
+type pageRequest struct {
+	limit  int
+	after  *pageCursor
+	before *pageCursor
+	// forwardOnly lists have no before cursor and link no previous page.
+	forwardOnly bool
+}
+
+// maxRows is how many rows to fetch for the page. One more row than asked
+// for tells setPage whether there is another page.
+func (p pageRequest) maxRows() int32 {
+	return int32(p.limit + 1)
+}
+
+func parsePageRequest(query url.Values) (pageRequest, error) {
+	page := pageRequest{limit: defaultPageSize}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			return page, errors.New("Limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		page.limit = n
+	}
+	after, before := query.Get("after"), query.Get("before")
+	if after != "" && before != "" {
+		return page, errors.New("Use either after or before, not both")
+	}
+	var err error
+	if after != "" {
+		page.after, err = parsePageCursor(after)
+	}
+	if before != "" {
+		page.before, err = parsePageCursor(before)
+	}
+	if err != nil {
+		return page, errors.New("Invalid cursor")
+	}
+	return page, nil
+}
+
+// parseForwardPageRequest is parsePageRequest for lists that are only paged
+// forward. what names the list in the error for a before cursor.
+func parseForwardPageRequest(query url.Values, what string) (pageRequest, error) {
+	page, err := parsePageRequest(query)
+	if err != nil {
+		return page, err
+	}
+	if page.before != nil {
+		return page, errors.New(what + " can only be paged forward")
+	}
+	page.forwardOnly = true
+	return page, nil
+}
+
+// setPage trims rows fetched with page.maxRows down to the page and links
+// the neighbouring pages with setPageLinks. Rows for a before cursor come
+// from a scan in the opposite order and are flipped back into list order.
+// cursor is the position of a row in the list.
+func setPage[T any](c *apiConfig, w http.ResponseWriter, req *http.Request, page pageRequest, rows []T, cursor func(T) pageCursor) []T {
+	hasMore := len(rows) > page.limit
+	if hasMore {
+		rows = rows[:page.limit]
+	}
+	backwards := page.before != nil
+	if backwards {
+		slices.Reverse(rows)
+	}
+
+	var next, prev *pageCursor
+	if len(rows) > 0 {
+		first, last := cursor(rows[0]), cursor(rows[len(rows)-1])
+		if backwards || hasMore {
+			next = &last
+		}
+		if !page.forwardOnly && (page.after != nil || backwards && hasMore) {
+			prev = &first
+		}
+	}
+	c.setPageLinks(w, req, next, prev)
+	return rows
+}
+
+// setPageLinks adds an RFC 8288 Link header with the next and prev pages,
+// the paging contract described at handlerGetChirps. The other query
+// parameters of the request are kept.
+func (c *apiConfig) setPageLinks(w http.ResponseWriter, req *http.Request, next, prev *pageCursor) {
+	link := func(param string, cursor *pageCursor, rel string) string {
+		query := req.URL.Query()
+		query.Del("after")
+		query.Del("before")
+		query.Set(param, cursor.String())
+		return "<" + c.baseURL + req.URL.Path + "?" + query.Encode() + `>; rel="` + rel + `"`
+	}
+	var links []string
+	if next != nil {
+		links = append(links, link("after", next, "next"))
+	}
+	if prev != nil {
+		links = append(links, link("before", prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPageCursor_RoundTrip(t *testing.T) {
+	createdAt := time.Date(2025, 3, 14, 15, 9, 26, 535897932, time.UTC)
+	tests := []struct {
+		name   string
+		cursor pageCursor
+	}{
+		{"Date", pageCursor{CreatedAt: createdAt, ID: uuid.New()}},
+		{"Rank", pageCursor{CreatedAt: createdAt, ID: uuid.New(), Rank: 0.0607927}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePageCursor(tt.cursor.String())
+			if err != nil {
+				t.Fatalf("parsePageCursor failed: %v", err)
+			}
+			if !got.CreatedAt.Equal(tt.cursor.CreatedAt) || got.ID != tt.cursor.ID || got.Rank != tt.cursor.Rank {
+				t.Errorf("Expected %+v, got %+v", tt.cursor, *got)
+			}
+		})
+	}
+}
+
+func TestParsePageCursor_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"not base64!",
+		"bm90IGEgY3Vyc29y",
+		pageCursor{ID: uuid.New()}.String() + "x",
+	}
+	for _, token := range tests {
+		if _, err := parsePageCursor(token); err == nil {
+			t.Errorf("Expected error for cursor %q", token)
+		}
+	}
+}
+
+func TestParsePageRequest(t *testing.T) {
+	cursor := pageCursor{CreatedAt: time.Now().UTC(), ID: uuid.New()}.String()
+	tests := []struct {
+		name    string
+		query   url.Values
+		wantErr string
+	}{
+		{"Defaults", url.Values{}, ""},
+		{"Limit", url.Values{"limit": {"100"}}, ""},
+		{"After", url.Values{"after": {cursor}}, ""},
+		{"Zero limit", url.Values{"limit": {"0"}}, "Limit must be between 1 and 100"},
+		{"Large limit", url.Values{"limit": {"101"}}, "Limit must be between 1 and 100"},
+		{"Bad limit", url.Values{"limit": {"ten"}}, "Limit must be between 1 and 100"},
+		{"Both cursors", url.Values{"after": {cursor}, "before": {cursor}}, "Use either after or before, not both"},
+		{"Bad cursor", url.Values{"before": {"nope"}}, "Invalid cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := parsePageRequest(tt.query)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePageRequest failed: %v", err)
+			}
+			if page.limit < 1 || page.limit > maxPageSize {
+				t.Errorf("Unexpected limit %d", page.limit)
+			}
+		})
+	}
+}
+
+func TestParseForwardPageRequest(t *testing.T) {
+	cursor := pageCursor{CreatedAt: time.Now().UTC(), ID: uuid.New()}.String()
+
+	page, err := parseForwardPageRequest(url.Values{"after": {cursor}}, "Likes")
+	if err != nil {
+		t.Fatalf("parseForwardPageRequest failed: %v", err)
+	}
+	if !page.forwardOnly || page.after == nil {
+		t.Errorf("Expected a forward only page after the cursor, got %+v", page)
+	}
+	_, err = parseForwardPageRequest(url.Values{"before": {cursor}}, "Likes")
+	if err == nil || err.Error() != "Likes can only be paged forward" {
+		t.Errorf("Expected error for a before cursor, got %v", err)
+	}
+}
+
+func TestSetPage(t *testing.T) {
+	start := time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)
+	rows := make([]pageCursor, 4)
+	for i := range rows {
+		rows[i] = pageCursor{CreatedAt: start.Add(time.Duration(i) * time.Minute), ID: uuid.New()}
+	}
+	reversed := slices.Clone(rows)
+	slices.Reverse(reversed)
+	link := func(param string, cursor pageCursor, rel string) string {
+		return "<https://chirpy.example.com/api/chirps?" + param + "=" + cursor.String() + `>; rel="` + rel + `"`
+	}
+
+	tests := []struct {
+		name     string
+		page     pageRequest
+		rows     []pageCursor
+		want     []pageCursor
+		wantLink string
+	}{
+		{
+			name:     "First page",
+			page:     pageRequest{limit: 3},
+			rows:     rows,
+			want:     rows[:3],
+			wantLink: link("after", rows[2], "next"),
+		},
+		{
+			name:     "Last page",
+			page:     pageRequest{limit: 3, after: &rows[0]},
+			rows:     rows[1:],
+			want:     rows[1:],
+			wantLink: link("before", rows[1], "prev"),
+		},
+		{
+			name:     "Backwards",
+			page:     pageRequest{limit: 2, before: &rows[3]},
+			rows:     reversed[1:],
+			want:     rows[1:3],
+			wantLink: link("after", rows[2], "next") + ", " + link("before", rows[1], "prev"),
+		},
+		{
+			name:     "Forward only",
+			page:     pageRequest{limit: 2, after: &rows[0], forwardOnly: true},
+			rows:     rows[1:],
+			want:     rows[1:3],
+			wantLink: link("after", rows[2], "next"),
+		},
+		{
+			name: "Empty",
+			page: pageRequest{limit: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &apiConfig{baseURL: "https://chirpy.example.com"}
+			req := httptest.NewRequest("GET", "/api/chirps", nil)
+			w := httptest.NewRecorder()
+			got := setPage(c, w, req, tt.page, slices.Clone(tt.rows), func(row pageCursor) pageCursor { return row })
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected rows %v, got %v", tt.want, got)
+			}
+			if link := w.Header().Get("Link"); link != tt.wantLink {
+				t.Errorf("Expected Link %q, got %q", tt.wantLink, link)
+			}
+		})
+	}
+}
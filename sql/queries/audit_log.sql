@@ -0,0 +1,14 @@
+-- name: CreateAuditLogEntry :exec
+INSERT INTO audit_log (created_at, actor_id, user_id, action, detail)
+VALUES (
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: ListAuditLog :many
+SELECT * FROM audit_log
+ORDER BY created_at DESC, id DESC
+LIMIT $1;
@@ -0,0 +1,22 @@
+-- name: LikeChirp :execrows
+-- Liking twice or liking a deleted chirp changes nothing.
+INSERT INTO chirp_likes (chirp_id, user_id, created_at)
+SELECT id, sqlc.arg(user_id), NOW() FROM chirps
+WHERE id = sqlc.arg(chirp_id) AND deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+-- name: UnlikeChirp :execrows
+DELETE FROM chirp_likes
+WHERE chirp_id = $1 AND user_id = $2;
+
+-- name: ListChirpLikes :many
+SELECT * FROM chirp_likes
+WHERE chirp_id = sqlc.arg(chirp_id)
+  AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+       OR (created_at, user_id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_user_id)::uuid))
+ORDER BY created_at ASC, user_id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListLikedChirpIDs :many
+SELECT chirp_id FROM chirp_likes
+WHERE user_id = sqlc.arg(user_id) AND chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]);
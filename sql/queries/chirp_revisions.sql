@@ -0,0 +1,12 @@
+-- name: CreateChirpRevision :exec
+INSERT INTO chirp_revisions (chirp_id, body, created_at, replaced_at)
+VALUES ($1, $2, $3, NOW());
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY id DESC;
+
+-- name: DeleteChirpRevisions :exec
+DELETE FROM chirp_revisions
+WHERE chirp_id = $1;
@@ -1,31 +1,166 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
    $1,
-   $2
+   $2,
+   $3
 )
 RETURNING *;
 
 
--- name: GetAllChirps :many
+-- name: ListChirpsAscending :many
 SELECT * FROM chirps
-ORDER BY created_at ASC;
+WHERE deleted_at IS NULL
+  AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+  AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+       OR (created_at, id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListChirpsDescending :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+  AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+       OR (created_at, id) < (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows);
 
 -- name: GetAllChirpsByAuthor :many
 SELECT * FROM chirps
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC;
 
 -- name: GetSingleChirp :one
 SELECT * FROM chirps
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetChirpForKeyShare :one
+SELECT * FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
+FOR KEY SHARE;
+
+-- name: GetChirpForUpdate :one
+SELECT * FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
 
 -- name: DeleteChirpByID :exec
 DELETE FROM chirps
 WHERE id = $1;
 
+-- name: ChirpHasReplies :one
+SELECT EXISTS (SELECT 1 FROM chirps WHERE in_reply_to = $1);
+
+-- name: TombstoneChirp :exec
+-- A tombstone keeps the place of a deleted chirp in its thread, nothing of
+-- what it said.
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
 -- name: DeleteAllChirps :exec
-DELETE FROM chirps;
\ No newline at end of file
+DELETE FROM chirps;
+
+-- name: SearchChirpsByRank :many
+-- The highlight tags must match search.HighlightStart and HighlightStop.
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, rank,
+       ts_headline('english', body, to_tsquery('english', sqlc.arg(query)),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet
+FROM (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count,
+           ts_rank(search, to_tsquery('english', sqlc.arg(query)))::real AS rank
+    FROM chirps
+    WHERE search @@ to_tsquery('english', sqlc.arg(query))
+      AND deleted_at IS NULL
+      AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+) AS matches
+WHERE sqlc.narg(cursor_id)::uuid IS NULL
+   OR (rank, id) < (sqlc.narg(cursor_rank)::real, sqlc.narg(cursor_id))
+ORDER BY rank DESC, id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: SearchChirpsAscending :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count,
+       ts_rank(search, to_tsquery('english', sqlc.arg(query)))::real AS rank,
+       ts_headline('english', body, to_tsquery('english', sqlc.arg(query)),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet
+FROM chirps
+WHERE search @@ to_tsquery('english', sqlc.arg(query))
+  AND deleted_at IS NULL
+  AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+  AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+       OR (created_at, id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- name: SearchChirpsDescending :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count,
+       ts_rank(search, to_tsquery('english', sqlc.arg(query)))::real AS rank,
+       ts_headline('english', body, to_tsquery('english', sqlc.arg(query)),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet
+FROM chirps
+WHERE search @@ to_tsquery('english', sqlc.arg(query))
+  AND deleted_at IS NULL
+  AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+  AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+       OR (created_at, id) < (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetThreadChirp :one
+-- Unlike GetSingleChirp this finds tombstones too.
+SELECT * FROM chirps
+WHERE id = $1;
+
+-- name: ListChirpReplies :many
+SELECT * FROM chirps
+WHERE in_reply_to = sqlc.arg(in_reply_to)
+  AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+       OR (created_at, id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListChirpAncestors :many
+-- Returns the chirp with the given ID and up to max_depth - 1 of the chirps
+-- above it, the root first.
+WITH RECURSIVE ancestors AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, 1 AS depth
+    FROM chirps
+    WHERE id = sqlc.arg(id)
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.in_reply_to, c.reply_count, c.like_count, c.deleted_at, a.depth + 1
+    FROM chirps c
+    JOIN ancestors a ON c.id = a.in_reply_to
+    WHERE a.depth < sqlc.arg(max_depth)
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, depth
+FROM ancestors
+ORDER BY depth DESC;
+
+-- name: ListChirpDescendants :many
+-- Returns the replies below the given chirps down to max_depth levels,
+-- breadth first so that max_rows cuts off the deepest replies.
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, 1 AS depth
+    FROM chirps
+    WHERE in_reply_to = ANY(sqlc.arg(parent_ids)::uuid[])
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.in_reply_to, c.reply_count, c.like_count, c.deleted_at, d.depth + 1
+    FROM chirps c
+    JOIN descendants d ON c.in_reply_to = d.id
+    WHERE d.depth < sqlc.arg(max_depth)
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, reply_count, like_count, deleted_at, depth
+FROM descendants
+ORDER BY depth, created_at, id
+LIMIT sqlc.arg(max_rows);
@@ -0,0 +1,18 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, created_at, user_id, email, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+   $2,
+   $3,
+   $4,
+   NULL
+);
+
+-- name: ConsumeEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING user_id, email;
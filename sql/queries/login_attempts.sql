@@ -0,0 +1,27 @@
+-- name: CreateLoginAttempt :exec
+INSERT INTO login_attempts (key, failures, last_failure_at)
+VALUES (sqlc.arg(key), 0, sqlc.arg(last_failure_at))
+ON CONFLICT (key) DO NOTHING;
+
+-- name: GetLoginAttemptForUpdate :one
+SELECT * FROM login_attempts
+WHERE key = $1
+FOR UPDATE;
+
+-- name: UpdateLoginAttempt :exec
+UPDATE login_attempts
+SET failures = sqlc.arg(failures), last_failure_at = sqlc.arg(last_failure_at)
+WHERE key = sqlc.arg(key);
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_attempts
+SET failures = GREATEST(failures - 1, 0),
+    last_failure_at = CASE
+        WHEN last_failure_at = sqlc.arg(reserved_at)::timestamp THEN sqlc.arg(previous_at)::timestamp
+        ELSE last_failure_at
+    END
+WHERE key = sqlc.arg(key);
+
+-- name: DeleteLoginAttempt :exec
+DELETE FROM login_attempts
+WHERE key = $1;
@@ -1,16 +1,75 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id,expires_at,revoked_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id,expires_at,revoked_at,family_id,user_agent,ip_address,session_started_at,last_used_at,client_id,scopes)
 VALUES (
     $1,
     NOW(),
     NOW(),
    $2, 
    $3,
-   NULL
+   NULL,
+   $4,
+   $5,
+   $6,
+   $7,
+   NOW(),
+   $8,
+   $9
 )
 RETURNING *;
 
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token_hash = $1;
+
 -- name: RevokeToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW(), replaced_by = $2
+WHERE token_hash = $1
+  AND revoked_at IS NULL
+  AND expires_at > NOW();
+
+-- name: RevokeTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+  AND revoked_at IS NULL;
+
+-- name: ListActiveSessions :many
+SELECT family_id, user_agent, ip_address, session_started_at, last_used_at, expires_at, client_id FROM refresh_tokens
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND family_id = $2
+  AND revoked_at IS NULL;
+
+-- name: RevokeAllUserTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeOtherUserTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+  AND family_id <> $2
+  AND revoked_at IS NULL;
+
+-- name: ListSessionHistory :many
+SELECT DISTINCT ON (family_id) family_id, user_agent, ip_address, session_started_at, last_used_at, expires_at, revoked_at, client_id FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY family_id, created_at DESC;
+
+-- name: SessionIsActive :one
+SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL);
@@ -0,0 +1,14 @@
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3,
+    $4
+);
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE provider = $1
+  AND subject = $2;
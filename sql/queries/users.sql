@@ -16,7 +16,7 @@ WHERE email = $1;
 -- name: GetUserFromRefreshToken :one
 SELECT users.* FROM users
 INNER JOIN refresh_tokens ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1
+WHERE refresh_tokens.token_hash = $1
   AND refresh_tokens.expires_at > NOW()
   AND refresh_tokens.revoked_at IS NULL;
 
@@ -33,4 +33,86 @@ SET is_chirpy_red = true, updated_at = NOW()
 WHERE id = $1;
 
 -- name: DeleteAllUsers :exec
-DELETE FROM users;
\ No newline at end of file
+DELETE FROM users;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: SetTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled_at = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1
+  AND totp_last_step < $2;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ConfirmUserEmail :one
+UPDATE users
+SET email = $2,
+    email_verified_at = NOW(),
+    pending_email = CASE WHEN pending_email = $2 THEN NULL ELSE pending_email END,
+    updated_at = NOW()
+WHERE id = $1
+  AND (email = $2 OR pending_email = $2)
+RETURNING *;
+
+-- name: UpgradePasswordHash :exec
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id)
+  AND hashed_password = sqlc.arg(old_hash);
+
+-- name: CountUsersByPasswordParams :many
+SELECT split_part(hashed_password, '$', 4)::text AS params, COUNT(*) AS users
+FROM users
+GROUP BY params
+ORDER BY users DESC;
+
+-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1
+  AND delete_after IS NOT NULL;
+
+-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE delete_after <= NOW();
@@ -0,0 +1,53 @@
+-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge_hash, created_at, user_id, ceremony, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: ConsumeWebAuthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge_hash = $1
+  AND ceremony = $2
+  AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenges
+WHERE expires_at <= NOW();
+
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, user_id, name, public_key, sign_count, last_used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    NULL
+)
+RETURNING *;
+
+-- name: GetWebAuthnCredential :one
+SELECT * FROM webauthn_credentials
+WHERE id = $1;
+
+-- name: ListWebAuthnCredentials :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: UpdateWebAuthnSignCount :execrows
+UPDATE webauthn_credentials
+SET sign_count = sqlc.arg(new_sign_count), last_used_at = NOW()
+WHERE id = sqlc.arg(id)
+  AND sign_count = sqlc.arg(old_sign_count);
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1
+  AND user_id = $2;
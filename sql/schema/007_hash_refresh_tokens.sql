@@ -0,0 +1,19 @@
+-- +goose Up
+-- Existing tokens are rehashed with the server pepper, which has to be passed
+-- to the migration as a session setting:
+--   PGOPTIONS="-c chirpy.refresh_token_pepper=$REFRESH_TOKEN_PEPPER" goose up
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+UPDATE refresh_tokens
+SET token_hash = encode(hmac(token_hash, current_setting('chirpy.refresh_token_pepper'), 'sha256'), 'hex'),
+    replaced_by = encode(hmac(replaced_by, current_setting('chirpy.refresh_token_pepper'), 'sha256'), 'hex');
+
+-- +goose Down
+-- Hashes can not be turned back into tokens, so every session is logged out.
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+ADD COLUMN session_started_at TIMESTAMP,
+ADD COLUMN last_used_at TIMESTAMP;
+
+UPDATE refresh_tokens
+SET session_started_at = created_at, last_used_at = created_at;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN session_started_at SET NOT NULL,
+ALTER COLUMN last_used_at SET NOT NULL;
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens(user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN last_used_at,
+DROP COLUMN session_started_at,
+DROP COLUMN ip_address,
+DROP COLUMN user_agent;
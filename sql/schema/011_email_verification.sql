@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN email_verified_at TIMESTAMP,
+ADD COLUMN pending_email TEXT;
+
+-- Accounts from before verification existed keep working when
+-- REQUIRE_VERIFIED_EMAIL is turned on.
+UPDATE users SET email_verified_at = created_at;
+
+CREATE TABLE email_verification_tokens(
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+
+ALTER TABLE users
+DROP COLUMN pending_email,
+DROP COLUMN email_verified_at;
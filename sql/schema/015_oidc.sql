@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE oidc_login_states(
+    state_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_identities(
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+-- +goose Down
+DROP TABLE user_identities;
+DROP TABLE oidc_login_states;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials(
+    id BYTEA PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials(user_id);
+
+-- Registrations belong to a user, logins do not know the user until the
+-- authenticator names the credential.
+CREATE TABLE webauthn_challenges(
+    challenge_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ceremony TEXT NOT NULL CHECK (ceremony IN ('register', 'login')),
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- No foreign keys: the trail has to outlive the accounts it mentions.
+CREATE TABLE audit_log(
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    actor_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    action TEXT NOT NULL,
+    detail TEXT NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log(created_at);
+
+-- +goose Down
+DROP TABLE audit_log;
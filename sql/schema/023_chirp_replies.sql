@@ -0,0 +1,47 @@
+-- +goose Up
+-- in_reply_to has no foreign key: a reply outlives the account of the
+-- chirp it answers, the thread shows a tombstone in its place.
+ALTER TABLE chirps
+    ADD COLUMN in_reply_to UUID,
+    ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps(in_reply_to, created_at, id);
+
+-- Tombstones all have an empty body, only live chirps have to be unique.
+ALTER TABLE chirps DROP CONSTRAINT chirps_body_key;
+CREATE UNIQUE INDEX chirps_body_key ON chirps(body) WHERE deleted_at IS NULL;
+
+-- reply_count counts the replies that are not deleted. A trigger keeps it
+-- right when replies go away with their author's account too.
+-- +goose StatementBegin
+CREATE FUNCTION chirps_count_replies() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET reply_count = reply_count + 1 WHERE id = NEW.in_reply_to;
+    ELSIF TG_OP = 'DELETE' AND OLD.deleted_at IS NULL THEN
+        UPDATE chirps SET reply_count = reply_count - 1 WHERE id = OLD.in_reply_to;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        UPDATE chirps SET reply_count = reply_count - 1 WHERE id = NEW.in_reply_to;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirps_count_replies
+AFTER INSERT OR DELETE OR UPDATE OF deleted_at ON chirps
+FOR EACH ROW
+EXECUTE FUNCTION chirps_count_replies();
+
+-- +goose Down
+DROP TRIGGER chirps_count_replies ON chirps;
+DROP FUNCTION chirps_count_replies();
+DROP INDEX chirps_body_key;
+DELETE FROM chirps WHERE deleted_at IS NOT NULL;
+ALTER TABLE chirps ADD CONSTRAINT chirps_body_key UNIQUE (body);
+DROP INDEX chirps_in_reply_to_idx;
+ALTER TABLE chirps
+    DROP COLUMN deleted_at,
+    DROP COLUMN reply_count,
+    DROP COLUMN in_reply_to;
@@ -0,0 +1,37 @@
+-- +goose Up
+CREATE TABLE chirp_likes(
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (chirp_id, user_id)
+);
+
+CREATE INDEX chirp_likes_chirp_id_created_at_idx ON chirp_likes(chirp_id, created_at, user_id);
+
+ALTER TABLE chirps ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+
+-- Like reply_count, the trigger keeps like_count right for concurrent likes
+-- and for likes that go away with an account.
+-- +goose StatementBegin
+CREATE FUNCTION chirps_count_likes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET like_count = like_count + 1 WHERE id = NEW.chirp_id;
+    ELSE
+        UPDATE chirps SET like_count = like_count - 1 WHERE id = OLD.chirp_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirps_count_likes
+AFTER INSERT OR DELETE ON chirp_likes
+FOR EACH ROW
+EXECUTE FUNCTION chirps_count_likes();
+
+-- +goose Down
+DROP TRIGGER chirps_count_likes ON chirp_likes;
+DROP FUNCTION chirps_count_likes();
+ALTER TABLE chirps DROP COLUMN like_count;
+DROP TABLE chirp_likes;
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Pepegakac123/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// newTestConfig returns an apiConfig backed by a fresh schema in the
+// database at CHIRPY_TEST_DB_URL, migrated up to the latest version. Tests
+// that need a database are skipped without one.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dbURL := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_DB_URL is not set")
+	}
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer admin.Close()
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		cleanup, err := sql.Open("postgres", dbURL)
+		if err != nil {
+			return
+		}
+		defer cleanup.Close()
+		cleanup.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatalf("Invalid CHIRPY_TEST_DB_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema+",public")
+	u.RawQuery = query.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrateUp(t, db)
+	return &apiConfig{db: database.New(db), sqlDB: db}
+}
+
+// migrateUp runs the goose Up sections of sql/schema in order.
+func migrateUp(t *testing.T, db *sql.DB) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("sql", "schema", "*.sql"))
+	if err != nil {
+		t.Fatalf("Failed to list migrations: %v", err)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		up, _, _ := strings.Cut(string(content), "-- +goose Down")
+		if _, err := db.Exec(up); err != nil {
+			t.Fatalf("Failed to apply %s: %v", file, err)
+		}
+	}
+}
+
+func createTestUser(t *testing.T, c *apiConfig) database.User {
+	t.Helper()
+	user, err := c.db.CreateUser(t.Context(), database.CreateUserParams{
+		Email:          fmt.Sprintf("%s@example.com", uuid.NewString()),
+		HashedPassword: "unset",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return user
+}